@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// alertRule fires when an action's rate reaches high and resolves only once
+// it falls below low, so a rate hovering around one threshold does not flap.
+type alertRule struct {
+	action uint32
+	high   float64
+	low    float64
+}
+
+// parseAlertRules builds rules from ACTION=rate pairs. Every action in lows
+// needs a matching entry in highs; actions without a low watermark resolve
+// as soon as they drop below the high one.
+func parseAlertRules(highs, lows []string) ([]alertRule, error) {
+	parse := func(flag string, pairs []string) (map[uint32]float64, error) {
+		rates := make(map[uint32]float64)
+		for _, pair := range pairs {
+			action, rate, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("--%s %q: expected ACTION=rate", flag, pair)
+			}
+			key, err := parseAction(action)
+			if err != nil {
+				return nil, fmt.Errorf("--%s %q: %w", flag, pair, err)
+			}
+			if rates[key], err = strconv.ParseFloat(rate, 64); err != nil {
+				return nil, fmt.Errorf("--%s %q: invalid rate: %w", flag, pair, err)
+			}
+		}
+		return rates, nil
+	}
+
+	high, err := parse("alert-high", highs)
+	if err != nil {
+		return nil, err
+	}
+	low, err := parse("alert-low", lows)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []alertRule
+	for key, h := range high {
+		l, ok := low[key]
+		if !ok {
+			l = h
+		}
+		if l > h {
+			return nil, fmt.Errorf("--alert-low for %s is above --alert-high", actionName(key))
+		}
+		rules = append(rules, alertRule{action: key, high: h, low: l})
+	}
+	for key := range low {
+		if _, ok := high[key]; !ok {
+			return nil, fmt.Errorf("--alert-low for %s has no matching --alert-high", actionName(key))
+		}
+	}
+	return rules, nil
+}
+
+// evaluateAlerts logs a FIRING or RESOLVED line for every rule whose state
+// changes with snapshot s.
+func (h *fexitHook) evaluateAlerts(rules []alertRule, s *Snapshot) {
+	if s.WarmingUp {
+		return
+	}
+	for _, rule := range rules {
+		name := actionName(rule.action)
+		a, ok := s.Actions[name]
+		if !ok {
+			continue
+		}
+		switch {
+		case !h.firing[rule.action] && a.Rate >= rule.high:
+			h.firing[rule.action] = true
+			h.logEvent("alert_firing", "FIRING: %s on %s at %.*f/s (high %.*f/s)", name, h.label(), precision, a.Rate, precision, rule.high)
+		case h.firing[rule.action] && a.Rate < rule.low:
+			h.firing[rule.action] = false
+			h.logEvent("alert_resolved", "RESOLVED: %s on %s at %.*f/s (low %.*f/s)", name, h.label(), precision, a.Rate, precision, rule.low)
+		}
+	}
+}
+
+// checkTripwire logs a TRIPPED line and reports true whenever the count of
+// action grew since the previous snapshot.
+func (h *fexitHook) checkTripwire(action uint32, s *Snapshot) bool {
+	a, ok := s.byCode(action)
+	if !ok || a.Count <= h.tripCount {
+		return false
+	}
+	h.logEvent("tripped", "TRIPPED: %s occurred %d times on %s (%d in total)", actionName(action), a.Count-h.tripCount, h.label(), a.Count)
+	h.tripCount = a.Count
+	return true
+}
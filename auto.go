@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// tcxAttachments returns where each program attached through TCX runs, as
+// "<iface> ingress|egress (tcx)" strings keyed by program ID, for the
+// interfaces in up.
+func tcxAttachments(up map[int]string) (map[int][]string, error) {
+	where := make(map[int][]string)
+	it := new(link.Iterator)
+	defer it.Close()
+	for it.Next() {
+		info, err := it.Link.Info()
+		if err != nil {
+			continue
+		}
+		tcx := info.TCX()
+		if tcx == nil {
+			continue
+		}
+		name, ok := up[int(tcx.Ifindex)]
+		if !ok {
+			continue
+		}
+		direction := "ingress"
+		if ebpf.AttachType(tcx.AttachType) == ebpf.AttachTCXEgress {
+			direction = "egress"
+		}
+		id := int(info.Program)
+		where[id] = append(where[id], fmt.Sprintf("%s %s (tcx)", name, direction))
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate links: %w", err)
+	}
+	return where, nil
+}
+
+// upInterfaces returns the names of the interfaces that are up, keyed by
+// index.
+func upInterfaces() (map[int]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	up := make(map[int]string)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 {
+			up[iface.Index] = iface.Name
+		}
+	}
+	return up, nil
+}
+
+// upAttachments returns where each TC program runs on the interfaces that
+// are up, keyed by program ID: TCX links, marked "(tcx)", and cls_bpf
+// filters on a clsact qdisc, marked "(clsact)". The two run in a different
+// order, TCX programs before the clsact filters of the same direction.
+func upAttachments() (map[int][]string, error) {
+	up, err := upInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	where, err := tcxAttachments(up)
+	if err != nil {
+		return nil, err
+	}
+	for index, name := range up {
+		ingress, egress, err := clsactPrograms(index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tc filters of %s: %w", name, err)
+		}
+		for _, id := range ingress {
+			where[id] = append(where[id], name+" ingress (clsact)")
+		}
+		for _, id := range egress {
+			where[id] = append(where[id], name+" egress (clsact)")
+		}
+	}
+	for _, points := range where {
+		sort.Strings(points)
+	}
+	return where, nil
+}
+
+// autoTargets returns every TC program attached to an interface that is up,
+// loaded within since (or at any time if since is zero), together with a
+// description of where it is attached.
+func autoTargets(since time.Duration) ([]*ebpf.Program, map[int]string, error) {
+	attachments, err := upAttachments()
+	if err != nil {
+		return nil, nil, err
+	}
+	progs, err := findPrograms(func(info *ebpf.ProgramInfo) bool {
+		id, _ := info.ID()
+		return len(attachments[int(id)]) > 0
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	progs = loadedWithin(progs, since)
+
+	where := make(map[int]string, len(progs))
+	for _, prog := range progs {
+		id := programID(prog)
+		where[id] = strings.Join(attachments[id], ", ")
+		fmt.Printf("Auto: monitoring program %d on %s\n", id, where[id])
+	}
+	return progs, where, nil
+}
+
+// parseClassid parses a class ID such as "1:10", with major and minor in
+// hex as tc prints them.
+func parseClassid(s string) (uint32, error) {
+	major, minor, ok := strings.Cut(s, ":")
+	hi, err := strconv.ParseUint(major, 16, 16)
+	if err != nil || !ok {
+		return 0, fmt.Errorf("%q is not a class ID such as 1:10", s)
+	}
+	lo, err := strconv.ParseUint(minor, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a class ID such as 1:10", s)
+	}
+	return uint32(hi)<<16 | uint32(lo), nil
+}
+
+// classidTargets returns the BPF programs that the root qdisc filters of
+// the interfaces that are up run for packets they put into classid, with a
+// description of where each runs.
+func classidTargets(classid uint32) ([]*ebpf.Program, map[int]string, error) {
+	up, err := upInterfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	name := fmt.Sprintf("%x:%x", classid>>16, classid&0xffff)
+	attachments := make(map[int][]string)
+	for index, iface := range up {
+		cls, act, err := classidPrograms(index, classid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list tc filters of %s: %w", iface, err)
+		}
+		for _, id := range cls {
+			attachments[id] = append(attachments[id], fmt.Sprintf("%s classid %s (cls_bpf)", iface, name))
+		}
+		for _, id := range act {
+			attachments[id] = append(attachments[id], fmt.Sprintf("%s classid %s (act_bpf)", iface, name))
+		}
+	}
+	if len(attachments) == 0 {
+		return nil, nil, fmt.Errorf("no filter on an interface that is up runs a BPF program for class ID %s: %w", name, ErrProgramNotFound)
+	}
+
+	ids := make([]int, 0, len(attachments))
+	for id := range attachments {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var progs []*ebpf.Program
+	where := make(map[int]string, len(ids))
+	for _, id := range ids {
+		prog, err := loadProgram(id)
+		if err != nil {
+			closePrograms(progs)
+			return nil, nil, err
+		}
+		sort.Strings(attachments[id])
+		where[id] = strings.Join(attachments[id], ", ")
+		fmt.Printf("Classid: monitoring program %d on %s\n", id, where[id])
+		progs = append(progs, prog)
+	}
+	return progs, where, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestParseClassid(t *testing.T) {
+	for s, want := range map[string]uint32{"1:10": 0x10010, "ffff:0": 0xffff0000, "a:ff": 0xa00ff} {
+		got, err := parseClassid(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if got != want {
+			t.Errorf("%q = %#x, want %#x", s, got, want)
+		}
+	}
+	for _, s := range []string{"", "1", "1:", ":10", "1:10000", "x:1"} {
+		if _, err := parseClassid(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
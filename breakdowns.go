@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+var familyOrder = []string{"IPv4", "IPv6", "OTHER"}
+
+var castOrder = []string{"unicast", "multicast", "broadcast", "non-Ethernet"}
+
+var ttlOrder = []string{"0-32", "33-64", "65-128", "129-255"}
+
+// printFamilyStats writes the per-action IPv4/IPv6/OTHER breakdown from
+// the family count map.
+func printFamilyStats(w io.Writer, ebpfMap *ebpf.Map) {
+	printSplit(w, "Address Families", ebpfMap, familyOrder)
+}
+
+// printCastStats writes the per-action unicast/multicast/broadcast
+// breakdown from the cast count map.
+func printCastStats(w io.Writer, ebpfMap *ebpf.Map) {
+	printSplit(w, "Destination MAC", ebpfMap, castOrder)
+}
+
+// printTTLStats writes the per-action distribution of the IPv4 TTL or IPv6
+// hop limit from the TTL count map. Non-IP packets are not counted.
+func printTTLStats(w io.Writer, ebpfMap *ebpf.Map) {
+	printSplit(w, "TTL / Hop Limit", ebpfMap, ttlOrder)
+}
+
+// printSplit writes a breakdown map indexed by action * len(names) + class,
+// one line per action.
+func printSplit(w io.Writer, title string, ebpfMap *ebpf.Map, names []string) {
+	fmt.Fprintf(w, "\n%s:\n", title)
+	numClasses := uint32(len(names))
+	for action := uint32(0); action < ebpfMap.MaxEntries()/numClasses; action++ {
+		fmt.Fprintf(w, "%s:", actionName(action))
+		for i, class := range names {
+			key := action*numClasses + uint32(i)
+			value, err := lookupCount(ebpfMap, &key)
+			if err != nil {
+				warnLog.Printf("Error looking up %s/%s: %v", actionName(action), class, err)
+				continue
+			}
+			fmt.Fprintf(w, " %s %d", class, value)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// printCPUStats writes how many packets each CPU ran the TC program for,
+// with the per-action split. CPUs that saw no packets are left out.
+func printCPUStats(w io.Writer, ebpfMap *ebpf.Map) {
+	fmt.Fprintln(w, "\nBy CPU:")
+	if !isPerCPU(ebpfMap) {
+		fmt.Fprintln(w, "action map is not per-CPU")
+		return
+	}
+
+	var perCPU [][]uint64 // [action][cpu]
+	var cpuTotals []uint64
+	var total uint64
+	for key := uint32(0); key < ebpfMap.MaxEntries(); key++ {
+		var values []uint64
+		if err := lookupRetry(ebpfMap, &key, &values); err != nil {
+			warnLog.Printf("Error looking up %s: %v", actionName(key), err)
+			values = nil
+		}
+		if cpuTotals == nil && values != nil {
+			cpuTotals = make([]uint64, len(values))
+		}
+		for cpu, v := range values {
+			cpuTotals[cpu] += v
+			total += v
+		}
+		perCPU = append(perCPU, values)
+	}
+
+	for cpu, cpuTotal := range cpuTotals {
+		if cpuTotal == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "CPU %d: %d (%.*f%%)", cpu, cpuTotal, precision, 100*float64(cpuTotal)/float64(total))
+		for action, values := range perCPU {
+			if cpu < len(values) && values[cpu] != 0 {
+				fmt.Fprintf(w, " %s %d", actionName(uint32(action)), values[cpu])
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// icmpKey mirrors struct icmp_key in tcmonitor.c.
+type icmpKey struct {
+	Family uint8
+	Type   uint8
+	Code   uint8
+	_      uint8
+}
+
+var icmpTypeNames = map[uint8]map[uint8]string{
+	4: {
+		0:  "echo-reply",
+		3:  "destination-unreachable",
+		5:  "redirect",
+		8:  "echo-request",
+		11: "time-exceeded",
+		12: "parameter-problem",
+	},
+	6: {
+		1:   "destination-unreachable",
+		2:   "packet-too-big",
+		3:   "time-exceeded",
+		4:   "parameter-problem",
+		128: "echo-request",
+		129: "echo-reply",
+		133: "router-solicitation",
+		134: "router-advertisement",
+		135: "neighbor-solicitation",
+		136: "neighbor-advertisement",
+		137: "redirect",
+	},
+}
+
+// printIcmpDrops writes the ICMP type/code breakdown of dropped packets,
+// busiest first.
+func printIcmpDrops(w io.Writer, ebpfMap *ebpf.Map) {
+	type entry struct {
+		key   icmpKey
+		count uint64
+	}
+	var entries []entry
+
+	var key icmpKey
+	iter := ebpfMap.Iterate()
+	if isPerCPU(ebpfMap) {
+		var values []uint64
+		for iter.Next(&key, &values) {
+			entries = append(entries, entry{key, sumPerCPU(values)})
+		}
+	} else {
+		var value uint64
+		for iter.Next(&key, &value) {
+			entries = append(entries, entry{key, value})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		warnLog.Printf("Error iterating ICMP drops: %v", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].count > entries[j].count })
+
+	fmt.Fprintln(w, "\nDropped ICMP:")
+	for _, e := range entries {
+		proto := "ICMP"
+		if e.key.Family == 6 {
+			proto = "ICMPv6"
+		}
+		name := icmpTypeNames[e.key.Family][e.key.Type]
+		if name == "" {
+			name = "unknown"
+		}
+		fmt.Fprintf(w, "%s type %d (%s) code %d: %d\n", proto, e.key.Type, name, e.key.Code, e.count)
+	}
+}
+
+// portKey mirrors struct port_key in tcmonitor.c.
+type portKey struct {
+	Action   uint32
+	Port     uint16
+	Proto    uint8
+	Fragment uint8
+}
+
+func (k portKey) String() string {
+	proto := fmt.Sprintf("proto%d", k.Proto)
+	switch k.Proto {
+	case 6:
+		proto = "tcp"
+	case 17:
+		proto = "udp"
+	}
+	if k.Fragment != 0 {
+		return proto + "/fragment"
+	}
+	return fmt.Sprintf("%s/%d", proto, k.Port)
+}
+
+// printTopPorts writes the n busiest destination ports of every action.
+// Non-first fragments, which carry no ports, are counted separately.
+func printTopPorts(w io.Writer, ebpfMap *ebpf.Map, n int) {
+	type entry struct {
+		key   portKey
+		count uint64
+	}
+	byAction := make(map[uint32][]entry)
+
+	var key portKey
+	var value uint64
+	iter := ebpfMap.Iterate()
+	for iter.Next(&key, &value) {
+		byAction[key.Action] = append(byAction[key.Action], entry{key, value})
+	}
+	if err := iter.Err(); err != nil {
+		warnLog.Printf("Error iterating ports: %v", err)
+	}
+
+	actions := make([]uint32, 0, len(byAction))
+	for action := range byAction {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+
+	fmt.Fprintln(w, "\nTop Ports:")
+	for _, action := range actions {
+		entries := byAction[action]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].count > entries[j].count })
+		if len(entries) > n {
+			entries = entries[:n]
+		}
+		fmt.Fprintf(w, "%s:", actionName(action))
+		for _, e := range entries {
+			fmt.Fprintf(w, " %s %d", e.key, e.count)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// valueKey mirrors struct mark_key and struct classid_key in tcmonitor.c.
+type valueKey struct {
+	Action uint32
+	Value  uint32
+}
+
+// printTopMarks writes the n most frequent skb marks of every action in
+// markMap. Packets whose mark is not among them, including those whose
+// entry the LRU map evicted, are shown as "other", taken from the action
+// totals in counts.
+func printTopMarks(w io.Writer, markMap *ebpf.Map, counts map[uint32]uint64, n int) {
+	printTopValues(w, "Marks", markMap, counts, n, func(mark uint32) string {
+		return fmt.Sprintf("%#x", mark)
+	})
+}
+
+// printTopClassids writes the n most frequent class IDs of every action in
+// classidMap, with "other" as in printTopMarks. Only the minor number is
+// known, so they are shown as ":minor" in hex, the way tc writes a class
+// of the filter's own qdisc. A class ID of 0, which the TC program did not
+// set, is shown as "unset".
+func printTopClassids(w io.Writer, classidMap *ebpf.Map, counts map[uint32]uint64, n int) {
+	printTopValues(w, "Class IDs", classidMap, counts, n, func(minor uint32) string {
+		if minor == 0 {
+			return "unset"
+		}
+		return fmt.Sprintf(":%x", minor)
+	})
+}
+
+// printTopValues writes the n most frequent values per action of a map
+// keyed by valueKey, followed by the remainder of the action's count.
+func printTopValues(w io.Writer, title string, ebpfMap *ebpf.Map, counts map[uint32]uint64, n int, format func(uint32) string) {
+	type entry struct {
+		value uint32
+		count uint64
+	}
+	byAction := make(map[uint32][]entry)
+
+	var key valueKey
+	var value uint64
+	iter := ebpfMap.Iterate()
+	for iter.Next(&key, &value) {
+		byAction[key.Action] = append(byAction[key.Action], entry{key.Value, value})
+	}
+	if err := iter.Err(); err != nil {
+		warnLog.Printf("Error iterating %s: %v", strings.ToLower(title), err)
+	}
+
+	actions := make([]uint32, 0, len(byAction))
+	for action := range byAction {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, action := range actions {
+		entries := byAction[action]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].count > entries[j].count })
+		if len(entries) > n {
+			entries = entries[:n]
+		}
+		fmt.Fprintf(w, "%s:", actionName(action))
+		var shown uint64
+		for _, e := range entries {
+			fmt.Fprintf(w, " %s %d", format(e.value), e.count)
+			shown += e.count
+		}
+		if total := counts[action]; total > shown {
+			fmt.Fprintf(w, " other %d", total-shown)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"sort"
+)
+
+// categoryOrder lists the --categorize categories in display order.
+// Categories only named in the labels file follow them alphabetically.
+var categoryOrder = []string{"PASS", "DROP", "REDIRECT", "OTHER"}
+
+// defaultCategories assigns actions to categories; actions missing from it
+// are OTHER.
+var defaultCategories = map[uint32]string{
+	0: "PASS",     // TC_ACT_OK
+	1: "PASS",     // TC_ACT_RECLASSIFY
+	3: "PASS",     // TC_ACT_PIPE
+	2: "DROP",     // TC_ACT_SHOT
+	4: "DROP",     // TC_ACT_STOLEN
+	7: "REDIRECT", // TC_ACT_REDIRECT
+}
+
+// categoryOverrides holds the "category ACTION=NAME" lines of the labels
+// file. It is only touched from the main goroutine.
+var categoryOverrides map[uint32]string
+
+// actionCategory returns the category of an action value.
+func actionCategory(code uint32) string {
+	if c, ok := categoryOverrides[code]; ok {
+		return c
+	}
+	if c, ok := defaultCategories[code]; ok {
+		return c
+	}
+	return "OTHER"
+}
+
+// writeCategories writes one line per category with the summed count, rate
+// and share of its actions. The default categories are always listed.
+func (s *Snapshot) writeCategories(w io.Writer) {
+	sums := make(map[string]ActionStats)
+	var extra []string
+	for _, a := range s.Actions {
+		c := actionCategory(a.Code)
+		sum, ok := sums[c]
+		if !ok && !isDefaultCategory(c) {
+			extra = append(extra, c)
+		}
+		sum.Count += a.Count
+		sum.Delta += a.Delta
+		sum.Rate += a.Rate
+		sum.Percent += a.Percent
+		sums[c] = sum
+	}
+	sort.Strings(extra)
+
+	var rows []tableRow
+	for _, c := range append(categoryOrder, extra...) {
+		sum := sums[c]
+		r := tableRow{name: c, count: sum.Count, rate: sum.Rate, percent: sum.Percent}
+		if s.Baseline {
+			r.delta = &sum.Delta
+		}
+		rows = append(rows, r)
+	}
+	writeRows(w, rows, s.WarmingUp)
+}
+
+func isDefaultCategory(c string) bool {
+	for _, d := range categoryOrder {
+		if c == d {
+			return true
+		}
+	}
+	return false
+}
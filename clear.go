@@ -0,0 +1,20 @@
+package main
+
+import "os"
+
+// ansiClear moves the cursor home and clears the screen.
+const ansiClear = "\033[H\033[J"
+
+// clearSequence returns what to print before every refresh: override if it
+// was given, nothing on dumb terminals or when $TERM is unset, and the ANSI
+// sequence otherwise.
+func clearSequence(override string, overridden bool) string {
+	if overridden {
+		return override
+	}
+	switch os.Getenv("TERM") {
+	case "", "dumb":
+		return ""
+	}
+	return ansiClear
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// lookupAttempts bounds how often a lookup failing with a transient error
+// is tried before the error is returned.
+const lookupAttempts = 3
+
+// isPerCPU reports whether m holds one value per CPU.
+func isPerCPU(m *ebpf.Map) bool {
+	switch m.Type() {
+	case ebpf.PerCPUArray, ebpf.PerCPUHash, ebpf.LRUCPUHash:
+		return true
+	}
+	return false
+}
+
+// sumPerCPU adds up per-CPU counter values.
+func sumPerCPU(values []uint64) uint64 {
+	var total uint64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// lookupCount reads the counter stored at key, summing over CPUs for
+// per-CPU maps, so callers work regardless of how tcmonitor.c declares
+// the map.
+func lookupCount(m *ebpf.Map, key any) (uint64, error) {
+	if isPerCPU(m) {
+		var values []uint64
+		if err := lookupRetry(m, key, &values); err != nil {
+			return 0, err
+		}
+		return sumPerCPU(values), nil
+	}
+
+	var value uint64
+	if err := lookupRetry(m, key, &value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// lookupRetry looks up key in m, retrying with a short backoff while the
+// syscall fails with a transient error such as EINTR. Other errors, like a
+// missing key, are returned right away.
+func lookupRetry(m *ebpf.Map, key, value any) error {
+	backoff := time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := m.Lookup(key, value)
+		if err == nil || !isTransient(err) || attempt == lookupAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether a failed map operation may succeed if retried.
+func isTransient(err error) bool {
+	return errors.Is(err, unix.EINTR) || errors.Is(err, unix.EAGAIN)
+}
+
+// actionCounts returns the counters of an action map keyed by action value,
+// along with the keys in ascending order. Array maps are read slot by slot,
+// skipping slots that fail with a warning. Hash maps only hold the actions
+// seen so far and are iterated instead. Either way the key must be a u32
+// action value and the value a u64 counter, or one per CPU.
+func actionCounts(m *ebpf.Map) (map[uint32]uint64, []uint32, error) {
+	counts := make(map[uint32]uint64)
+	switch m.Type() {
+	case ebpf.Array, ebpf.PerCPUArray:
+		for key := uint32(0); key < m.MaxEntries(); key++ {
+			value, err := lookupCount(m, &key)
+			if err != nil {
+				warnLog.Printf("Error looking up %s: %v", actionName(key), err)
+				continue
+			}
+			counts[key] = value
+		}
+	default:
+		var key uint32
+		iter := m.Iterate()
+		if isPerCPU(m) {
+			var values []uint64
+			for iter.Next(&key, &values) {
+				counts[key] = sumPerCPU(values)
+			}
+		} else {
+			var value uint64
+			for iter.Next(&key, &value) {
+				counts[key] = value
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return counts, slices.Sorted(maps.Keys(counts)), nil
+}
+
+// addCount adds delta to the counter at key. For per-CPU maps the delta is
+// credited to the first CPU.
+func addCount(m *ebpf.Map, key any, delta uint64) error {
+	if isPerCPU(m) {
+		var values []uint64
+		if err := m.Lookup(key, &values); err != nil {
+			return err
+		}
+		values[0] += delta
+		return m.Put(key, values)
+	}
+
+	var value uint64
+	if err := m.Lookup(key, &value); err != nil {
+		return err
+	}
+	value += delta
+	return m.Put(key, value)
+}
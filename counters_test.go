@@ -0,0 +1,90 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// newTestMap creates an action map of type typ, skipping the test where
+// BPF maps cannot be created, such as without CAP_BPF.
+func newTestMap(t *testing.T, typ ebpf.MapType, maxEntries uint32) *ebpf.Map {
+	t.Helper()
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Skipf("Cannot remove memlock rlimit: %v", err)
+	}
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       typ,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: maxEntries,
+	})
+	if err != nil {
+		t.Skipf("Cannot create %s map: %v", typ, err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestActionCounts(t *testing.T) {
+	cpus, err := ebpf.PossibleCPU()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		typ  ebpf.MapType
+		want map[uint32]uint64
+	}{
+		// Arrays hold every slot, hashes only the keys that were written.
+		{ebpf.Array, map[uint32]uint64{0: 5, 1: 0, 2: 7, 3: 0}},
+		{ebpf.PerCPUArray, map[uint32]uint64{0: 5, 1: 0, 2: 7, 3: 0}},
+		{ebpf.Hash, map[uint32]uint64{0: 5, 2: 7}},
+		{ebpf.LRUHash, map[uint32]uint64{0: 5, 2: 7}},
+		{ebpf.PerCPUHash, map[uint32]uint64{0: 5, 2: 7}},
+		{ebpf.LRUCPUHash, map[uint32]uint64{0: 5, 2: 7}},
+	} {
+		t.Run(test.typ.String(), func(t *testing.T) {
+			m := newTestMap(t, test.typ, 4)
+			for key, count := range map[uint32]uint64{0: 5, 2: 7} {
+				var err error
+				if isPerCPU(m) {
+					// Spread the count over the CPUs to check the sum.
+					values := make([]uint64, cpus)
+					values[0] = count
+					if cpus > 1 {
+						values[0], values[cpus-1] = count-1, 1
+					}
+					err = m.Put(key, values)
+				} else {
+					err = m.Put(key, count)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			counts, keys, err := actionCounts(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(counts, test.want) {
+				t.Errorf("counts = %v, want %v", counts, test.want)
+			}
+			if want := slices.Sorted(maps.Keys(test.want)); !slices.Equal(keys, want) {
+				t.Errorf("keys = %v, want %v", keys, want)
+			}
+
+			count, err := lookupCount(m, uint32(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 7 {
+				t.Errorf("lookupCount(2) = %d, want 7", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+// dashboard serves the latest snapshots as an auto-refreshing HTML page
+// and as JSON.
+type dashboard struct {
+	mu    sync.Mutex
+	hooks []hookSnapshot
+}
+
+// update replaces the served snapshots.
+func (d *dashboard) update(hooks []hookSnapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks = hooks
+}
+
+func (d *dashboard) serveData(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	hooks := d.hooks
+	d.mu.Unlock()
+
+	if hooks == nil {
+		hooks = []hookSnapshot{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hooks); err != nil {
+		warnLog.Printf("Error writing dashboard data: %v", err)
+	}
+}
+
+func (d *dashboard) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	err := dashboardTemplate.Execute(w, struct {
+		Title         string
+		DataPath      string
+		RefreshMillis int64
+	}{
+		Title:         "tcmonitor",
+		DataPath:      "/data.json",
+		RefreshMillis: time.Second.Milliseconds(),
+	})
+	if err != nil {
+		warnLog.Printf("Error rendering dashboard: %v", err)
+	}
+}
+
+// serve starts the dashboard on addr in the background.
+func (d *dashboard) serve(addr string) (net.Listener, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.servePage)
+	mux.HandleFunc("/data.json", d.serveData)
+	return serveHTTP("Dashboard", addr, mux)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// programID returns the kernel ID of prog, or 0 if it cannot be determined.
+func programID(prog *ebpf.Program) int {
+	info, err := prog.Info()
+	if err != nil {
+		return 0
+	}
+	id, _ := info.ID()
+	return int(id)
+}
+
+// loadProgram opens the program with the given ID.
+func loadProgram(id int) (*ebpf.Program, error) {
+	prog, err := ebpf.NewProgramFromID(ebpf.ProgramID(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load TC program ID %d: %w", id, ErrProgramNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TC program ID %d: %w", id, err)
+	}
+	return prog, nil
+}
+
+// programFromLink opens the program behind the BPF link with the given ID,
+// which must be a TC program.
+func programFromLink(id int) (*ebpf.Program, error) {
+	l, err := link.NewFromID(link.ID(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load link ID %d: %w", id, ErrProgramNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load link ID %d: %w", id, err)
+	}
+	defer l.Close()
+
+	info, err := l.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info of link %d: %w", id, err)
+	}
+	prog, err := loadProgram(int(info.Program))
+	if err != nil {
+		return nil, fmt.Errorf("link %d: %w", id, err)
+	}
+	progInfo, err := prog.Info()
+	if err != nil {
+		prog.Close()
+		return nil, fmt.Errorf("failed to get info of program %d: %w", info.Program, err)
+	}
+	if progInfo.Type != ebpf.SchedCLS && progInfo.Type != ebpf.SchedACT {
+		prog.Close()
+		return nil, fmt.Errorf("link %d points at program %d of type %s: %w", id, info.Program, progInfo.Type, ErrNotTCProgram)
+	}
+	return prog, nil
+}
+
+// parseProgramIDs returns the program ID in arg, or reads one ID per line
+// from stdin if arg is "-". Blank lines and lines starting with # are
+// skipped.
+func parseProgramIDs(arg string, stdin io.Reader) ([]int, error) {
+	if arg != "-" {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%q is not a program ID", arg)
+		}
+		return []int{id}, nil
+	}
+
+	var ids []int
+	scanner := bufio.NewScanner(stdin)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, err := strconv.Atoi(text)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("stdin line %d: %q is not a program ID", line, text)
+		}
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no program IDs on stdin")
+	}
+	return ids, nil
+}
+
+// findPrograms returns all loaded TC programs for which match returns true.
+// Programs that are unloaded while iterating are skipped.
+func findPrograms(match func(*ebpf.ProgramInfo) bool) ([]*ebpf.Program, error) {
+	var progs []*ebpf.Program
+	var id ebpf.ProgramID
+	for {
+		var err error
+		id, err = ebpf.ProgramGetNextID(id)
+		if errors.Is(err, os.ErrNotExist) {
+			return progs, nil
+		}
+		if err != nil {
+			closePrograms(progs)
+			return nil, fmt.Errorf("failed to get next program ID: %w", err)
+		}
+
+		prog, err := ebpf.NewProgramFromID(id)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			closePrograms(progs)
+			return nil, fmt.Errorf("failed to load program ID %d: %w", id, err)
+		}
+
+		info, err := prog.Info()
+		if err != nil || (info.Type != ebpf.SchedCLS && info.Type != ebpf.SchedACT) || !match(info) {
+			prog.Close()
+			continue
+		}
+		progs = append(progs, prog)
+	}
+}
+
+// findProgramsByPrefix returns all loaded TC programs whose name starts with
+// prefix.
+func findProgramsByPrefix(prefix string) ([]*ebpf.Program, error) {
+	return findPrograms(func(info *ebpf.ProgramInfo) bool {
+		return strings.HasPrefix(info.Name, prefix)
+	})
+}
+
+// findProgramsByName returns the loaded TC programs called name, oldest
+// first. The kernel keeps only the first 15 bytes of a program name, so
+// longer names are compared by that prefix.
+func findProgramsByName(name string) ([]*ebpf.Program, error) {
+	if len(name) > 15 {
+		name = name[:15]
+	}
+	progs, err := findPrograms(func(info *ebpf.ProgramInfo) bool {
+		return info.Name == name
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(progs, func(i, j int) bool {
+		return loadTime(progs[i]) < loadTime(progs[j])
+	})
+	return progs, nil
+}
+
+// loadTime returns how long after boot prog was loaded, or 0 if unknown.
+func loadTime(prog *ebpf.Program) time.Duration {
+	info, err := prog.Info()
+	if err != nil {
+		return 0
+	}
+	t, _ := info.LoadTime()
+	return t
+}
+
+// loadedWithin returns the programs in progs that were loaded during the
+// last d, closing the others. A zero d keeps every program.
+func loadedWithin(progs []*ebpf.Program, d time.Duration) []*ebpf.Program {
+	if d <= 0 {
+		return progs
+	}
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts); err != nil {
+		log.Printf("Not filtering by load time: %v", err)
+		return progs
+	}
+	// Load times are reported relative to boot, like CLOCK_BOOTTIME.
+	cutoff := time.Duration(ts.Nano()) - d
+
+	var recent []*ebpf.Program
+	for _, prog := range progs {
+		if loadTime(prog) >= cutoff {
+			recent = append(recent, prog)
+			continue
+		}
+		prog.Close()
+	}
+	return recent
+}
+
+// selectByName narrows the programs matching --name down to one. With a
+// single match it is returned as is. Otherwise index picks a match in the
+// order listed by findProgramsByName, or all of them if all is set; if
+// neither is given the matches are printed and an error returned.
+func selectByName(name string, progs []*ebpf.Program, index int, all bool) ([]*ebpf.Program, error) {
+	if len(progs) == 0 {
+		return nil, fmt.Errorf("no TC program is called %q: %w", name, ErrProgramNotFound)
+	}
+	if len(progs) == 1 || all {
+		return progs, nil
+	}
+	if index >= 0 && index < len(progs) {
+		selected := progs[index]
+		closePrograms(append(progs[:index:index], progs[index+1:]...))
+		return []*ebpf.Program{selected}, nil
+	}
+
+	fmt.Printf("%d TC programs are called %q:\n", len(progs), name)
+	for i, prog := range progs {
+		var tag string
+		var uid uint32
+		if info, err := prog.Info(); err == nil {
+			tag = info.Tag
+			uid, _ = info.CreatedByUID()
+		}
+		fmt.Printf("  [%d] ID %d tag %s loaded %v after boot by UID %d\n", i, programID(prog), tag, loadTime(prog).Round(time.Second), uid)
+	}
+	closePrograms(progs)
+	if index >= 0 {
+		return nil, fmt.Errorf("--select-index %d is out of range", index)
+	}
+	return nil, errors.New("pick one with --select-index, or trace all of them with --all-matches")
+}
+
+// scanBPFFS walks root and returns every pinned TC program beneath it.
+// Entries that cannot be read are skipped with a warning.
+func scanBPFFS(root string) ([]*ebpf.Program, error) {
+	var progs []*ebpf.Program
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			log.Printf("Skipping %s: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		prog, err := ebpf.LoadPinnedProgram(path, &ebpf.LoadPinOptions{ReadOnly: true})
+		if err != nil {
+			// Not a program pin, or not accessible.
+			return nil
+		}
+		info, err := prog.Info()
+		if err != nil || (info.Type != ebpf.SchedCLS && info.Type != ebpf.SchedACT) {
+			prog.Close()
+			return nil
+		}
+		fmt.Printf("Found pinned TC program %d at %s\n", programID(prog), path)
+		progs = append(progs, prog)
+		return nil
+	})
+	if err != nil {
+		closePrograms(progs)
+		return nil, err
+	}
+	return progs, nil
+}
+
+// dedupPrograms drops programs whose ID already appeared earlier in progs,
+// closing the duplicates.
+func dedupPrograms(progs []*ebpf.Program) []*ebpf.Program {
+	seen := make(map[int]bool)
+	var unique []*ebpf.Program
+	for _, prog := range progs {
+		id := programID(prog)
+		if seen[id] {
+			prog.Close()
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, prog)
+	}
+	return unique
+}
+
+// limitPrograms keeps at most max programs, closing the rest and warning
+// about them. A max of zero or less keeps everything.
+func limitPrograms(progs []*ebpf.Program, max int) []*ebpf.Program {
+	if max <= 0 || len(progs) <= max {
+		return progs
+	}
+	log.Printf("Found %d TC programs, only tracing the first %d (raise --max-programs to trace more)", len(progs), max)
+	closePrograms(progs[max:])
+	return progs[:max]
+}
+
+func closePrograms(progs []*ebpf.Program) {
+	for _, prog := range progs {
+		prog.Close()
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseProgramIDs(t *testing.T) {
+	for _, test := range []struct {
+		arg, stdin string
+		want       []int
+	}{
+		{"42", "", []int{42}},
+		{"-", "1\n\n# comment\n  2  \n3\n", []int{1, 2, 3}},
+	} {
+		ids, err := parseProgramIDs(test.arg, strings.NewReader(test.stdin))
+		if err != nil {
+			t.Errorf("%q: %v", test.arg, err)
+			continue
+		}
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("%q: ids = %v, want %v", test.arg, ids, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		arg, stdin string
+	}{
+		{"0", ""},
+		{"-3", ""},
+		{"eth0", ""},
+		{"-", ""},
+		{"-", "# only comments\n"},
+		{"-", "1\nfoo\n"},
+		{"-", "0\n"},
+	} {
+		if _, err := parseProgramIDs(test.arg, strings.NewReader(test.stdin)); err == nil {
+			t.Errorf("%q with stdin %q: expected an error", test.arg, test.stdin)
+		}
+	}
+}
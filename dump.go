@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// dumpMaps writes every entry of the hook's maps without any name mapping,
+// for debugging mismatches between tcmonitor.c and the Go key tables.
+func dumpMaps(w io.Writer, h *fexitHook) {
+	maps := h.counterMaps()
+	names := make([]string, 0, len(maps))
+	for name := range maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := maps[name]
+		fmt.Fprintf(w, "\n%s [%s] %s max_entries=%d key_size=%d value_size=%d\n",
+			name, h.label(), m.Type(), m.MaxEntries(), m.KeySize(), m.ValueSize())
+
+		var key, value []byte
+		var values [][]byte
+		iter := m.Iterate()
+		if isPerCPU(m) {
+			for iter.Next(&key, &values) {
+				fmt.Fprintf(w, "%s:", formatRaw(key))
+				for cpu, v := range values {
+					fmt.Fprintf(w, " cpu%d=%s", cpu, formatRaw(v))
+				}
+				fmt.Fprintln(w)
+			}
+		} else {
+			for iter.Next(&key, &value) {
+				fmt.Fprintf(w, "%s: %s\n", formatRaw(key), formatRaw(value))
+			}
+		}
+		if err := iter.Err(); err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		}
+	}
+}
+
+// formatRaw prints 4 and 8 byte values as native-endian integers and
+// anything else as hex.
+func formatRaw(b []byte) string {
+	switch len(b) {
+	case 4:
+		return fmt.Sprint(binary.NativeEndian.Uint32(b))
+	case 8:
+		return fmt.Sprint(binary.NativeEndian.Uint64(b))
+	default:
+		return fmt.Sprintf("%#x", b)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+)
+
+// environmentHeader describes the host tcmonitor runs on, so that pasted
+// output carries the context needed for bug reports.
+func environmentHeader() string {
+	kernel := "unknown"
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		kernel = unix.ByteSliceToString(uts.Release[:])
+	}
+
+	btfStatus := "available"
+	if _, err := btf.LoadKernelSpec(); err != nil {
+		btfStatus = fmt.Sprintf("unavailable (%v)", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Kernel: %s\n", kernel)
+	fmt.Fprintf(&b, "Architecture: %s\n", runtime.GOARCH)
+	fmt.Fprintf(&b, "Kernel BTF: %s\n", btfStatus)
+	fmt.Fprintf(&b, "tcmonitor: %s\n", versionString())
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/cilium/ebpf"
+)
+
+// Errors returned by the attach pipeline, for use with errors.Is.
+var (
+	ErrProgramNotFound = errors.New("program not found")
+	ErrNotTCProgram    = errors.New("program is not a TC program")
+	ErrNoBTF           = errors.New("program does not have BTF ID")
+	ErrNoFexit         = errors.New("kernel cannot attach fexit programs to BPF programs")
+)
+
+// errUsage matches the errors of an invalid command line, which exit with
+// exitUsage.
+var errUsage = errors.New("invalid command line")
+
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string        { return e.msg }
+func (e *usageError) Is(target error) bool { return target == errUsage }
+
+// usagef returns an error matching errUsage with the formatted message.
+func usagef(format string, args ...any) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// Process exit codes. 2 is what pflag uses for usage errors, so
+// tcmonitor's own codes start at 3.
+const (
+	exitFailure           = 1
+	exitUsage             = 2
+	exitProgramNotFound   = 3
+	exitNotTCProgram      = 4
+	exitNoBTF             = 5
+	exitVerifier          = 6
+	exitTripped           = 7
+	exitNoTraffic         = 8
+	exitNoFexit           = 9
+	exitConditionHeld     = 10
+	exitExpectationFailed = 11
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, ErrProgramNotFound):
+		return exitProgramNotFound
+	case errors.Is(err, ErrNotTCProgram):
+		return exitNotTCProgram
+	case errors.Is(err, ErrNoBTF):
+		return exitNoBTF
+	case errors.Is(err, ErrNoFexit):
+		return exitNoFexit
+	case errors.As(err, new(*ebpf.VerifierError)):
+		return exitVerifier
+	default:
+		return exitFailure
+	}
+}
+
+// failed logs err, including the verifier log if there is one, and returns
+// the exit code matching err for run to return. Returning instead of
+// exiting lets run's deferred cleanup happen.
+func failed(err error) int {
+	if ve := new(ebpf.VerifierError); errors.As(err, &ve) {
+		log.Printf("%v\nVerifier log:\n%v", err, ve)
+	} else {
+		log.Print(err)
+	}
+	return exitCode(err)
+}
+
+// failf logs the formatted message and returns exitFailure.
+func failf(format string, args ...any) int {
+	log.Printf(format, args...)
+	return exitFailure
+}
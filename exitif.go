@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// exitOps is ordered so that two-character operators match first.
+var exitOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// comparison is a parsed NAME<op>VALUE expression as used by --exit-if and
+// --expect. action is nil for TOTAL.
+type comparison struct {
+	expr   string
+	action *uint32
+	op     string
+	value  float64
+}
+
+// parseComparison parses NAME<op>VALUE, where NAME is a TC_ACT_* name with or
+// without its prefix, ACTION_<n>, or TOTAL if allowTotal is set. VALUE is
+// passed to parseValue.
+func parseComparison(expr string, allowTotal bool, parseValue func(string) (float64, error)) (*comparison, error) {
+	for _, op := range exitOps {
+		name, value, ok := strings.Cut(expr, op)
+		if !ok {
+			continue
+		}
+		c := &comparison{expr: expr, op: op}
+		name = strings.TrimSpace(name)
+		if name != "TOTAL" || !allowTotal {
+			key, err := parseAction(name)
+			if err != nil {
+				key, err = parseAction("TC_ACT_" + name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%q: unknown action %q", expr, name)
+			}
+			c.action = &key
+		}
+		v, err := parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+		c.value = v
+		return c, nil
+	}
+	return nil, fmt.Errorf("%q: expected an action, one of %s, and a value", expr, strings.Join(exitOps, " "))
+}
+
+// holds reports whether n compares to the expression's value as required.
+func (c *comparison) holds(n float64) bool {
+	switch c.op {
+	case ">=":
+		return n >= c.value
+	case "<=":
+		return n <= c.value
+	case "==":
+		return n == c.value
+	case "!=":
+		return n != c.value
+	case ">":
+		return n > c.value
+	default:
+		return n < c.value
+	}
+}
+
+// parseExitCondition parses an --exit-if expression such as "SHOT>100",
+// comparing the final count of an action, or of all actions, to a value.
+func parseExitCondition(expr string) (*comparison, error) {
+	return parseComparison(expr, true, func(s string) (float64, error) {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid count %q", s)
+		}
+		return float64(n), nil
+	})
+}
+
+// exitConditionHolds evaluates an --exit-if expression against the final
+// per-action counts. TOTAL leaves out the actions excluded with
+// --exclude-from-total, like Snapshot.Total.
+func exitConditionHolds(c *comparison, counts map[uint32]uint64) bool {
+	var n uint64
+	if c.action != nil {
+		n = counts[*c.action]
+	} else {
+		for action, v := range counts {
+			if !excludedFromTotal[action] {
+				n += v
+			}
+		}
+	}
+	return c.holds(float64(n))
+}
+
+// parseExpectation parses an --expect expression such as "OK>=99%",
+// comparing an action's share of the total to a percentage.
+func parseExpectation(expr string) (*comparison, error) {
+	return parseComparison(expr, false, func(s string) (float64, error) {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || !strings.HasSuffix(s, "%") {
+			return 0, fmt.Errorf("invalid percentage %q", s)
+		}
+		return p, nil
+	})
+}
+
+// checkExpectations returns a message for every expectation that the final
+// counts violate. Shares are taken over the actions not excluded with
+// --exclude-from-total, like the percentages in the table.
+func checkExpectations(expects []*comparison, counts map[uint32]uint64) []string {
+	var total uint64
+	for action, n := range counts {
+		if !excludedFromTotal[action] {
+			total += n
+		}
+	}
+
+	var failed []string
+	for _, c := range expects {
+		var share float64
+		if total > 0 {
+			share = 100 * float64(counts[*c.action]) / float64(total)
+		}
+		if !c.holds(share) {
+			failed = append(failed, fmt.Sprintf("expected %s, observed %.*f%% (%.*f points off)",
+				c.expr, precision, share, precision, math.Abs(share-c.value)))
+		}
+	}
+	return failed
+}
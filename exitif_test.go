@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestParseExitCondition(t *testing.T) {
+	shot := uint32(2)
+	for _, test := range []struct {
+		expr   string
+		action *uint32
+		op     string
+		value  float64
+	}{
+		{"SHOT>100", &shot, ">", 100},
+		{"TC_ACT_SHOT >= 100", &shot, ">=", 100},
+		{"ACTION_2!=0", &shot, "!=", 0},
+		{"TOTAL<=5", nil, "<=", 5},
+		{"OK==0", new(uint32), "==", 0},
+	} {
+		c, err := parseExitCondition(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if (c.action == nil) != (test.action == nil) || c.action != nil && *c.action != *test.action {
+			t.Errorf("%q: action = %v, want %v", test.expr, c.action, test.action)
+		}
+		if c.op != test.op || c.value != test.value {
+			t.Errorf("%q: got %s %v, want %s %v", test.expr, c.op, c.value, test.op, test.value)
+		}
+	}
+
+	for _, expr := range []string{"SHOT", "SHOT>", "SHOT>-1", "SHOT>1.5", "BOGUS>1", ">1"} {
+		if _, err := parseExitCondition(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestParseExpectation(t *testing.T) {
+	c, err := parseExpectation("SHOT<1.5%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.op != "<" || c.value != 1.5 {
+		t.Errorf("got %s %v, want < 1.5", c.op, c.value)
+	}
+
+	// Percentages need their sign, and TOTAL always holds 100%.
+	for _, expr := range []string{"SHOT<1.5", "TOTAL<50%"} {
+		if _, err := parseExpectation(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestComparisonHolds(t *testing.T) {
+	for _, test := range []struct {
+		expr string
+		n    float64
+		want bool
+	}{
+		{"SHOT>10", 11, true},
+		{"SHOT>10", 10, false},
+		{"SHOT>=10", 10, true},
+		{"SHOT<10", 10, false},
+		{"SHOT<=10", 10, true},
+		{"SHOT==10", 10, true},
+		{"SHOT!=10", 10, false},
+	} {
+		c, err := parseExitCondition(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.holds(test.n); got != test.want {
+			t.Errorf("%q holds for %v = %v, want %v", test.expr, test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// freplaceLink describes an extension (freplace) program attached to a
+// function of another program.
+type freplaceLink struct {
+	extensionID ebpf.ProgramID
+	targetID    uint32
+}
+
+// freplaceLinks lists every freplace link on the system.
+func freplaceLinks() ([]freplaceLink, error) {
+	var links []freplaceLink
+	it := new(link.Iterator)
+	defer it.Close()
+	for it.Next() {
+		info, err := it.Link.Info()
+		if err != nil || info.Type != link.TracingType {
+			continue
+		}
+		tr := info.Tracing()
+		if tr == nil || tr.TargetObjId == 0 {
+			continue
+		}
+		prog, err := ebpf.NewProgramFromID(info.Program)
+		if err != nil {
+			continue
+		}
+		progInfo, err := prog.Info()
+		prog.Close()
+		if err != nil || progInfo.Type != ebpf.Extension {
+			continue
+		}
+		links = append(links, freplaceLink{extensionID: info.Program, targetID: tr.TargetObjId})
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate links: %w", err)
+	}
+	return links, nil
+}
+
+// findExtensions returns the freplace programs attached to prog.
+func findExtensions(prog *ebpf.Program) ([]*ebpf.Program, error) {
+	links, err := freplaceLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	id := uint32(programID(prog))
+	var exts []*ebpf.Program
+	for _, l := range links {
+		if l.targetID != id {
+			continue
+		}
+		ext, err := ebpf.NewProgramFromID(l.extensionID)
+		if err != nil {
+			continue
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// extendsTCProgram reports whether the extension prog replaces a function
+// of a TC program.
+func extendsTCProgram(prog *ebpf.Program) bool {
+	links, err := freplaceLinks()
+	if err != nil {
+		return false
+	}
+
+	id := ebpf.ProgramID(programID(prog))
+	for _, l := range links {
+		if l.extensionID != id {
+			continue
+		}
+		target, err := ebpf.NewProgramFromID(ebpf.ProgramID(l.targetID))
+		if err != nil {
+			continue
+		}
+		info, err := target.Info()
+		target.Close()
+		if err == nil && (info.Type == ebpf.SchedCLS || info.Type == ebpf.SchedACT) {
+			return true
+		}
+	}
+	return false
+}
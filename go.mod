@@ -4,7 +4,7 @@ go 1.23.1
 
 require (
 	github.com/cilium/ebpf v0.17.3
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/spf13/pflag v1.0.6
+	golang.org/x/sys v0.30.0
 )
-
-require golang.org/x/sys v0.30.0 // indirect
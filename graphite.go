@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// graphiteSink sends snapshots to a Graphite server using the plaintext
+// protocol. Writing happens in the background, so an unreachable server
+// does not delay the display. The connection is redialed on the next send
+// after any error.
+type graphiteSink struct {
+	addr   string
+	prefix string
+	conn   net.Conn
+	queue  chan []byte
+}
+
+func newGraphiteSink(ctx context.Context, addr, prefix string) *graphiteSink {
+	g := &graphiteSink{
+		addr:   addr,
+		prefix: strings.TrimSuffix(prefix, "."),
+		queue:  make(chan []byte, 4),
+	}
+	go g.run(ctx)
+	return g
+}
+
+// add queues the lines of snapshots. If the writer is too far behind, they
+// are dropped with a warning.
+func (g *graphiteSink) add(snapshots []*Snapshot) {
+	lines := graphiteLines(g.prefix, snapshots)
+	if len(lines) == 0 {
+		return
+	}
+	select {
+	case g.queue <- lines:
+	default:
+		warnLog.Printf("Dropping metrics for Graphite at %s: previous writes are still pending", g.addr)
+	}
+}
+
+func (g *graphiteSink) run(ctx context.Context) {
+	defer g.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case lines := <-g.queue:
+			if err := g.write(lines); err != nil {
+				warnLog.Printf("Error sending to Graphite: %v", err)
+			}
+		}
+	}
+}
+
+func (g *graphiteSink) write(lines []byte) error {
+	if g.conn == nil {
+		conn, err := net.DialTimeout("tcp", g.addr, 2*time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", g.addr, err)
+		}
+		g.conn = conn
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if _, err := g.conn.Write(lines); err != nil {
+		g.close()
+		return fmt.Errorf("failed to write to %s: %w", g.addr, err)
+	}
+	return nil
+}
+
+func (g *graphiteSink) close() {
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+// graphiteLines builds one "<metric> <count> <timestamp>" line per action of
+// every snapshot, with metric names from metricNames.
+func graphiteLines(prefix string, snapshots []*Snapshot) []byte {
+	var buf bytes.Buffer
+	names := metricNames(prefix, snapshots, graphiteName)
+	for i, s := range snapshots {
+		for _, action := range s.order {
+			fmt.Fprintf(&buf, "%s.%s %d %d\n", names[i], graphiteName(action), s.Actions[action].Count, s.Timestamp.Unix())
+		}
+	}
+	return buf.Bytes()
+}
+
+// metricNames returns the metric name of each snapshot, to which the sinks
+// append the action: "<prefix>.prog<ID>", or "<prefix>.prog<ID>.<function>"
+// for programs with more than one hook, so that those do not write the
+// same series. escape replaces the characters the sink treats specially.
+func metricNames(prefix string, snapshots []*Snapshot, escape func(string) string) []string {
+	hooksPerProgram := make(map[int]int)
+	for _, s := range snapshots {
+		hooksPerProgram[s.ProgramID]++
+	}
+	names := make([]string, len(snapshots))
+	for i, s := range snapshots {
+		names[i] = fmt.Sprintf("%s.prog%d", prefix, s.ProgramID)
+		if hooksPerProgram[s.ProgramID] > 1 {
+			names[i] += "." + escape(s.Function)
+		}
+	}
+	return names
+}
+
+// graphiteName replaces characters that Graphite treats as separators.
+func graphiteName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', '\t', '/':
+			return '_'
+		}
+		return r
+	}, s)
+}
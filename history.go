@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// snapshotRing keeps the most recent snapshots of a hook, overwriting the
+// oldest one once it is full.
+type snapshotRing struct {
+	buf  []*Snapshot
+	next int
+	full bool
+}
+
+func newSnapshotRing(size int) *snapshotRing {
+	return &snapshotRing{buf: make([]*Snapshot, size)}
+}
+
+func (r *snapshotRing) add(s *Snapshot) {
+	r.buf[r.next] = s
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshots returns the retained snapshots, oldest first.
+func (r *snapshotRing) snapshots() []*Snapshot {
+	if !r.full {
+		return r.buf[:r.next]
+	}
+	return append(append([]*Snapshot{}, r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// writeHistory writes one line per retained snapshot with the per-second
+// rate of every action during that interval.
+func writeHistory(w io.Writer, label string, r *snapshotRing) {
+	fmt.Fprintf(w, "\nHistory (%s):\n", label)
+	for _, s := range r.snapshots() {
+		s.writeRates(w)
+	}
+}
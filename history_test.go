@@ -0,0 +1,35 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSnapshotRing(t *testing.T) {
+	ids := func(r *snapshotRing) []int {
+		var ids []int
+		for _, s := range r.snapshots() {
+			ids = append(ids, s.ProgramID)
+		}
+		return ids
+	}
+
+	r := newSnapshotRing(3)
+	if got := ids(r); len(got) != 0 {
+		t.Errorf("empty ring holds %v", got)
+	}
+	for i, want := range [][]int{
+		{1},
+		{1, 2},
+		{1, 2, 3},
+		{2, 3, 4},
+		{3, 4, 5},
+		{4, 5, 6},
+		{5, 6, 7},
+	} {
+		r.add(&Snapshot{ProgramID: i + 1})
+		if got := ids(r); !slices.Equal(got, want) {
+			t.Errorf("after %d adds: %v, want %v", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpAttempts bounds how often a batch is posted before it is dropped.
+const httpAttempts = 3
+
+// httpSink POSTs snapshots to a collector as newline-delimited JSON, one
+// line per refresh holding the snapshot of every hook. Posting happens in
+// the background so a slow collector does not delay the display.
+type httpSink struct {
+	url     string
+	headers http.Header
+	batch   int
+	client  *http.Client
+
+	pending bytes.Buffer
+	lines   int
+	queue   chan []byte
+}
+
+// newHTTPSink parses headers given as "Name: value" and starts the poster.
+func newHTTPSink(ctx context.Context, url string, headers []string, batch int) (*httpSink, error) {
+	h := make(http.Header)
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, fmt.Errorf("header %q: expected Name: value", header)
+		}
+		h.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if batch < 1 {
+		batch = 1
+	}
+
+	s := &httpSink{
+		url:     url,
+		headers: h,
+		batch:   batch,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		queue:   make(chan []byte, 4),
+	}
+	go s.run(ctx)
+	return s, nil
+}
+
+// add appends one refresh and queues the batch once it is full. If the
+// poster is too far behind, the batch is dropped with a warning.
+func (s *httpSink) add(snapshots []hookSnapshot) {
+	if snapshots == nil {
+		snapshots = []hookSnapshot{}
+	}
+	if err := json.NewEncoder(&s.pending).Encode(snapshots); err != nil {
+		warnLog.Printf("Error encoding snapshot for %s: %v", s.url, err)
+		return
+	}
+	s.lines++
+	if s.lines < s.batch {
+		return
+	}
+
+	body := bytes.Clone(s.pending.Bytes())
+	s.pending.Reset()
+	s.lines = 0
+	select {
+	case s.queue <- body:
+	default:
+		warnLog.Printf("Dropping snapshots for %s: previous posts are still pending", s.url)
+	}
+}
+
+func (s *httpSink) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-s.queue:
+			if err := s.post(ctx, body); err != nil {
+				warnLog.Printf("Dropping snapshots for %s: %v", s.url, err)
+			}
+		}
+	}
+}
+
+// post sends body, retrying with exponential backoff on network errors,
+// 429 and 5xx responses.
+func (s *httpSink) post(ctx context.Context, body []byte) error {
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= httpAttempts; attempt++ {
+		var retry bool
+		if retry, err = s.postOnce(ctx, body); err == nil || !retry {
+			return err
+		}
+		if attempt == httpAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", httpAttempts, err)
+}
+
+// postOnce sends body once and reports whether a failure is worth retrying.
+func (s *httpSink) postOnce(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPSink(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Header, body}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink, err := newHTTPSink(ctx, srv.URL, []string{"Authorization: Bearer secret"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is posted before the batch is full.
+	hooks := []hookSnapshot{{Label: "cls_main (program 42)", Snapshot: testSnapshot()}}
+	sink.add(hooks)
+	sink.add(hooks)
+
+	var req request
+	select {
+	case req = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch posted")
+	}
+	if got := req.header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := req.header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+
+	var lines int
+	scanner := bufio.NewScanner(bytes.NewReader(req.body))
+	for scanner.Scan() {
+		var got []hookSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		if len(got) != 1 || got[0].Label != hooks[0].Label || got[0].Actions["TC_ACT_SHOT"].Count != 25 {
+			t.Errorf("line %d: %+v", lines+1, got)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("posted %d lines, want 2", lines)
+	}
+}
+
+func TestHTTPSinkRetry(t *testing.T) {
+	for _, test := range []struct {
+		status   int
+		attempts int32
+		ok       bool
+	}{
+		{http.StatusServiceUnavailable, 2, true},
+		{http.StatusTooManyRequests, 2, true},
+		{http.StatusBadRequest, 1, false},
+	} {
+		var attempts atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Fail the first attempt only.
+			if attempts.Add(1) == 1 {
+				w.WriteHeader(test.status)
+			}
+		}))
+
+		sink := &httpSink{url: srv.URL, client: srv.Client()}
+		err := sink.post(context.Background(), []byte("[]\n"))
+		srv.Close()
+		if (err == nil) != test.ok {
+			t.Errorf("%d: err = %v", test.status, err)
+		}
+		if got := attempts.Load(); got != test.attempts {
+			t.Errorf("%d: %d attempts, want %d", test.status, got, test.attempts)
+		}
+	}
+
+	if _, err := newHTTPSink(context.Background(), "http://localhost", []string{"no colon"}, 1); err == nil {
+		t.Error("malformed header: expected an error")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ifaceStats tracks an interface's kernel packet counters, which are the
+// same rtnl_link_stats64 values rtnetlink reports, for comparison against
+// the TC action totals.
+type ifaceStats struct {
+	name     string
+	prevRx   uint64
+	prevTx   uint64
+	prevTime time.Time
+}
+
+func newIfaceStats(name string) (*ifaceStats, error) {
+	st := &ifaceStats{name: name}
+	rx, tx, err := st.read()
+	if err != nil {
+		return nil, err
+	}
+	st.prevRx, st.prevTx, st.prevTime = rx, tx, time.Now()
+	return st, nil
+}
+
+func (st *ifaceStats) read() (rx, tx uint64, err error) {
+	readCounter := func(counter string) (uint64, error) {
+		b, err := os.ReadFile(filepath.Join("/sys/class/net", st.name, "statistics", counter))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s of %s: %w", counter, st.name, err)
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+	if rx, err = readCounter("rx_packets"); err != nil {
+		return 0, 0, err
+	}
+	if tx, err = readCounter("tx_packets"); err != nil {
+		return 0, 0, err
+	}
+	return rx, tx, nil
+}
+
+// print writes the interface counters next to tcTotal and tcRate, the sum
+// over all hooks. A TC rate well below the interface rate hints at traffic
+// that bypasses the traced programs.
+func (st *ifaceStats) print(w io.Writer, tcTotal uint64, tcRate float64) {
+	rx, tx, err := st.read()
+	if err != nil {
+		warnLog.Printf("Error reading interface stats: %v", err)
+		return
+	}
+	now := time.Now()
+	deltaTime := now.Sub(st.prevTime).Seconds()
+	if deltaTime == 0 {
+		return
+	}
+	rxRate := float64(rx-st.prevRx) / deltaTime
+	txRate := float64(tx-st.prevTx) / deltaTime
+	st.prevRx, st.prevTx, st.prevTime = rx, tx, now
+
+	fmt.Fprintf(w, "\nInterface %s: rx %d (Rate: %.*f/s), tx %d (Rate: %.*f/s); TC total %d (Rate: %.*f/s)\n",
+		st.name, rx, precision, rxRate, tx, precision, txRate, tcTotal, precision, tcRate)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// actionLabels overrides the displayed name of actions, keyed by action
+// value. It is only touched from the main goroutine.
+var actionLabels map[uint32]string
+
+// loadLabels parses a labels file. Each non-empty line that does not start
+// with '#' maps an action to a display label, or, prefixed with "category",
+// moves it to another --categorize category:
+//
+//	TC_ACT_SHOT=blocked
+//	10=custom-action
+//	category TC_ACT_PIPE=OTHER
+func loadLabels(path string) (map[uint32]string, map[uint32]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	labels := make(map[uint32]string)
+	categories := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		target := labels
+		if rest, ok := strings.CutPrefix(line, "category "); ok {
+			target, line = categories, rest
+		}
+		action, label, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("%s:%d: expected ACTION=label", path, lineNo)
+		}
+		key, err := parseAction(strings.TrimSpace(action))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		target[key] = strings.TrimSpace(label)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := checkLabelCollisions(labels); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return labels, categories, nil
+}
+
+// checkLabelCollisions rejects labels that would show two actions under the
+// same name: the same label for two actions, or a label that is the name of
+// another action that keeps its own name. Snapshots are keyed by name, so
+// such actions would overwrite each other.
+func checkLabelCollisions(labels map[uint32]string) error {
+	used := make(map[string]uint32, len(labels))
+	for key, label := range labels {
+		if other, ok := used[label]; ok {
+			return fmt.Errorf("label %q is used for both %s and %s", label, defaultActionName(min(key, other)), defaultActionName(max(key, other)))
+		}
+		used[label] = key
+		if other, err := parseAction(label); err == nil && other != key && defaultActionName(other) == label {
+			if _, relabelled := labels[other]; !relabelled {
+				return fmt.Errorf("label %q for %s is the name of another action", label, defaultActionName(key))
+			}
+		}
+	}
+	return nil
+}
+
+// parseAction resolves a TC_ACT_* name, ACTION_<n> or a plain number to an
+// action value.
+func parseAction(s string) (uint32, error) {
+	if key, ok := tcKeys[s]; ok {
+		return key, nil
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "ACTION_"), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown action %q", s)
+	}
+	return uint32(n), nil
+}
+
+// watchFile watches the directory containing path, so that editors which
+// replace the file instead of writing it in place are noticed too.
+func watchFile(path string) (*fsnotify.Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// isFileChange reports whether ev means path may have new contents.
+func isFileChange(ev fsnotify.Event, path string) bool {
+	return filepath.Clean(ev.Name) == filepath.Clean(path) && ev.Has(fsnotify.Write|fsnotify.Create)
+}
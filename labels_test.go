@@ -0,0 +1,59 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLabels writes content to a labels file in a temporary directory.
+func writeLabels(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "labels")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLabels(t *testing.T) {
+	path := writeLabels(t, `# comment
+
+TC_ACT_SHOT = blocked
+10=custom-action
+ACTION_11=other
+category TC_ACT_PIPE=OTHER
+`)
+	labels, categories, err := loadLabels(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[uint32]string{2: "blocked", 10: "custom-action", 11: "other"}; !maps.Equal(labels, want) {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+	if want := map[uint32]string{3: "OTHER"}; !maps.Equal(categories, want) {
+		t.Errorf("categories = %v, want %v", categories, want)
+	}
+}
+
+func TestLoadLabelsErrors(t *testing.T) {
+	for _, content := range []string{
+		"TC_ACT_SHOT",
+		"BOGUS=x",
+		"category TC_ACT_SHOT",
+		// The same label for two actions.
+		"TC_ACT_SHOT=drop\nTC_ACT_STOLEN=drop\n",
+		// The name of another action that keeps its own name.
+		"TC_ACT_SHOT=TC_ACT_OK\n",
+	} {
+		if _, _, err := loadLabels(writeLabels(t, content)); err == nil {
+			t.Errorf("%q: expected an error", content)
+		}
+	}
+
+	// Swapping two names is fine, since neither keeps its own.
+	if _, _, err := loadLabels(writeLabels(t, "TC_ACT_SHOT=TC_ACT_OK\nTC_ACT_OK=TC_ACT_SHOT\n")); err != nil {
+		t.Errorf("swapped labels: %v", err)
+	}
+}
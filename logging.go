@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// jsonLogs is set by --log-format json.
+var jsonLogs bool
+
+// setupLogging configures the diagnostic log on stderr. With "json", every
+// log line, including those from the log package, becomes a JSON record.
+func setupLogging(format string) error {
+	switch format {
+	case "text":
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+		jsonLogs = true
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// logEvent logs an event concerning h. JSON records carry the event name,
+// program_id and func as separate fields.
+func (h *fexitHook) logEvent(event, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if jsonLogs {
+		slog.Info(msg, "event", event, "program_id", h.progID, "func", h.funcName)
+		return
+	}
+	log.Print(msg)
+}
@@ -3,24 +3,41 @@ package main
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -target bpf tcmonitor tcmonitor.c
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/rlimit"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/pflag"
 )
 
+// defaultCookie is "tcmon" in ASCII, so tcmonitor's links stand out.
+const defaultCookie = 0x74636d6f6e
+
+// tracingCookies is cleared once the kernel rejects a cookie on a tracing
+// link, so later attaches go without one.
+var tracingCookies = true
+
 var (
 	tcKeys = map[string]uint32{
-		"TC_ACT_OK":        0,
+		"TC_ACT_OK":         0,
 		"TC_ACT_RECLASSIFY": 1,
 		"TC_ACT_SHOT":       2,
 		"TC_ACT_PIPE":       3,
@@ -33,124 +50,1283 @@ var (
 	tcKeyOrder = []string{"TC_ACT_OK", "TC_ACT_RECLASSIFY", "TC_ACT_SHOT", "TC_ACT_PIPE", "TC_ACT_STOLEN", "TC_ACT_QUEUED", "TC_ACT_REPEAT", "TC_ACT_REDIRECT", "TC_ACT_TRAP"}
 )
 
+// fexitHook is a single fexit instance attached to one function of the
+// traced TC program, with its own counter map.
+type fexitHook struct {
+	prog       *ebpf.Program
+	progID     int
+	funcName   string
+	cookie     uint64
+	obj        *tcmonitorObjects
+	link       link.Link
+	prevValues map[uint32]uint64
+	prevTime   time.Time
+	history    *snapshotRing
+	sparks     *sparklines
+	firing     map[uint32]bool
+	tripCount  uint64
+	simulated  bool
+	pinnedMap  string // set for --read-map hooks, which have no program
+	where      string
+	nested     bool              // packets are also counted by another hook, see markNested
+	baseline   map[uint32]uint64 // --baseline counts, nil without it
+
+	// pinPath is the --pinned-prog path the program was loaded from. If
+	// followPin replaced prog, ownsProg is set and prog is closed with h.
+	pinPath  string
+	ownsProg bool
+
+	// cloneOf is the hook whose maps this one shares, if the program is a
+	// clone of an earlier one. Only the leader is displayed, with the IDs of
+	// its clones.
+	cloneOf *fexitHook
+	clones  []int
+
+	backoff    time.Duration
+	nextAttach time.Time
+}
+
 func getFuncName(prog *ebpf.Program) (string, error) {
+	funcs, err := getFuncs(prog)
+	if err != nil {
+		return "", err
+	}
+	return funcs[0].Name, nil
+}
+
+// getHookableFuncNames returns the names of all functions in prog whose
+// signature matches fexit_tc: a single argument and an integer return value.
+// The entry function comes first.
+func getHookableFuncNames(prog *ebpf.Program) ([]string, error) {
+	funcs, err := getFuncs(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, fn := range funcs {
+		proto, ok := fn.Type.(*btf.FuncProto)
+		if !ok || len(proto.Params) != 1 {
+			log.Printf("Skipping %s: function does not take exactly one argument", fn.Name)
+			continue
+		}
+		if _, ok := btf.UnderlyingType(proto.Return).(*btf.Int); !ok {
+			log.Printf("Skipping %s: function does not return an integer", fn.Name)
+			continue
+		}
+		names = append(names, fn.Name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no hookable function found in program")
+	}
+	return names, nil
+}
+
+// matchFuncNames returns the hookable functions of prog whose name matches re.
+func matchFuncNames(prog *ebpf.Program, re *regexp.Regexp) ([]string, error) {
+	names, err := getHookableFuncNames(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for _, name := range names {
+		if re.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no hookable function matches %q (hookable: %s)", re, strings.Join(names, ", "))
+	}
+	if re.String() != "" {
+		log.Printf("Functions of program %d matching %q: %s", programID(prog), re, strings.Join(matched, ", "))
+	}
+	return matched, nil
+}
+
+// getFuncs returns the BTF functions of prog in instruction order, starting
+// with the entry function.
+func getFuncs(prog *ebpf.Program) ([]*btf.Func, error) {
 	info, err := prog.Info()
 	if err != nil {
-		return "", fmt.Errorf("failed to get program info: %w", err)
+		return nil, fmt.Errorf("failed to get program info: %w", err)
 	}
 
-	if info.Type != ebpf.SchedCLS && info.Type != ebpf.SchedACT {
-		return "", fmt.Errorf("program is not a TC program")
+	if info.Type != ebpf.SchedCLS && info.Type != ebpf.SchedACT &&
+		!(info.Type == ebpf.Extension && extendsTCProgram(prog)) {
+		return nil, ErrNotTCProgram
 	}
 
 	if _, ok := info.BTFID(); !ok {
-		return "", fmt.Errorf("program does not have BTF ID")
+		return nil, ErrNoBTF
 	}
 
 	insns, err := info.Instructions()
 	if err != nil {
-		return "", fmt.Errorf("failed to get program instructions: %w", err)
+		return nil, fmt.Errorf("failed to get program instructions: %w", err)
 	}
 
-	for _, insn := range insns {
-		if sym := insn.Symbol(); sym != "" {
-			return sym, nil
+	var funcs []*btf.Func
+	for i := range insns {
+		if fn := btf.FuncMetadata(&insns[i]); fn != nil {
+			funcs = append(funcs, fn)
 		}
 	}
-	return "", fmt.Errorf("no entry function found in program")
+	if len(funcs) == 0 {
+		return nil, fmt.Errorf("no entry function found in program")
+	}
+	return funcs, nil
 }
 
-func lookupAndPrintStats(ebpfMap *ebpf.Map, prevValues map[string]uint64, prevTime *time.Time) {
-	fmt.Println("\nTC Actions:")
-	now := time.Now()
- 	deltaTime := now.Sub(*prevTime).Seconds()
- 	if deltaTime == 0 {
- 		return // Avoid division by zero
- 	}
+// funcSource returns the file:line where funcName in prog is defined,
+// taken from the program's BTF line info, or "" if it has none.
+func funcSource(prog *ebpf.Program, funcName string) string {
+	info, err := prog.Info()
+	if err != nil {
+		return ""
+	}
+	insns, err := info.Instructions()
+	if err != nil {
+		return ""
+	}
+
+	inFunc := false
+	for i := range insns {
+		if fn := btf.FuncMetadata(&insns[i]); fn != nil {
+			if inFunc {
+				return "" // Reached the next function without line info.
+			}
+			inFunc = fn.Name == funcName
+		}
+		if !inFunc {
+			continue
+		}
+		if line, ok := insns[i].Source().(*btf.Line); ok {
+			return fmt.Sprintf("%s:%d", line.FileName(), line.LineNumber())
+		}
+	}
+	return ""
+}
+
+// attachFexit loads a copy of spec with its fexit program targeting funcName
+// inside tcProg and attaches it with the given BPF cookie. Maps in
+// replacements are used instead of creating new ones.
+func attachFexit(spec *ebpf.CollectionSpec, tcProg *ebpf.Program, funcName string, cookie uint64, replacements map[string]*ebpf.Map) (*tcmonitorObjects, link.Link, error) {
+	spec = spec.Copy()
+	tcFexit := spec.Programs["fexit_tc"]
+	tcFexit.AttachTarget = tcProg
+	tcFexit.AttachTo = funcName
+
+	obj := new(tcmonitorObjects)
+	opts := &ebpf.CollectionOptions{MapReplacements: replacements}
+	if err := spec.LoadAndAssign(obj, opts); err != nil {
+		return nil, nil, fmt.Errorf("failed to load BPF object: %w", err)
+	}
+
+	if !tracingCookies {
+		cookie = 0
+	}
+	l, err := link.AttachTracing(link.TracingOptions{
+		Program:    obj.FexitTc,
+		AttachType: ebpf.AttachTraceFExit,
+		Cookie:     cookie,
+	})
+	if cookie != 0 && errors.Is(err, ebpf.ErrNotSupported) {
+		// Tracing links only take a cookie since Linux 6.0.
+		log.Print("Kernel does not support cookies on tracing links, attaching without --cookie")
+		tracingCookies = false
+		l, err = link.AttachTracing(link.TracingOptions{
+			Program:    obj.FexitTc,
+			AttachType: ebpf.AttachTraceFExit,
+		})
+	}
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		err = fmt.Errorf("%w: %v", ErrNoFexit, err)
+	}
+	if err != nil {
+		obj.Close()
+		return nil, nil, fmt.Errorf("failed to attach fexit program: %w", err)
+	}
+	return obj, l, nil
+}
+
+// hookProgram attaches fexit to the entry function of tcProg, or to every
+// hookable function whose name matches funcRegex if it is not nil.
+//
+// If leaders is not nil, tcProg is treated as a clone of an earlier program
+// with the same tag: hooks on the same function share the earlier hook's
+// maps, so the kernel sums their counters, and are recorded in leaders
+// otherwise.
+func hookProgram(spec *ebpf.CollectionSpec, tcProg *ebpf.Program, funcRegex *regexp.Regexp, cookie uint64, leaders map[string]*fexitHook) ([]*fexitHook, error) {
+	progID := programID(tcProg)
+
+	var tag string
+	if info, err := tcProg.Info(); err == nil {
+		tag = info.Tag
+	}
+
+	var funcNames []string
+	var err error
+	if funcRegex != nil {
+		funcNames, err = matchFuncNames(tcProg, funcRegex)
+	} else {
+		var tcFuncName string
+		tcFuncName, err = getFuncName(tcProg)
+		funcNames = []string{tcFuncName}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get function name of program %d: %w", progID, err)
+	}
+
+	hooks := make([]*fexitHook, 0, len(funcNames))
+	for _, funcName := range funcNames {
+		leaderKey := tag + "/" + funcName
+		leader := leaders[leaderKey]
+		var replacements map[string]*ebpf.Map
+		if leader != nil {
+			replacements = leader.counterMaps()
+		}
+
+		obj, l, err := attachFexit(spec, tcProg, funcName, cookie, replacements)
+		if err != nil {
+			for _, h := range hooks {
+				h.link.Close()
+				h.obj.Close()
+			}
+			return nil, fmt.Errorf("failed to hook %s of program %d: %w", funcName, progID, err)
+		}
+
+		h := &fexitHook{
+			prog:       tcProg,
+			progID:     progID,
+			funcName:   funcName,
+			cookie:     cookie,
+			obj:        obj,
+			link:       l,
+			prevValues: make(map[uint32]uint64),
+			firing:     make(map[uint32]bool),
+			cloneOf:    leader,
+		}
+		hooks = append(hooks, h)
+	}
+
+	// Only record clones and leaders once every function attached, so that
+	// a failure leaves no references to the closed hooks above.
+	for _, h := range hooks {
+		switch {
+		case h.cloneOf != nil:
+			h.cloneOf.clones = append(h.cloneOf.clones, progID)
+		case leaders != nil && tag != "":
+			leaders[tag+"/"+h.funcName] = h
+		}
+	}
+	return hooks, nil
+}
+
+func (h *fexitHook) label() string {
+	if h.pinnedMap != "" {
+		return "pinned map " + h.pinnedMap
+	}
+	if h.where != "" && len(h.clones) == 0 {
+		return fmt.Sprintf("%s (program %d on %s)", h.funcName, h.progID, h.where)
+	}
+	if len(h.clones) == 0 {
+		return fmt.Sprintf("%s (program %d)", h.funcName, h.progID)
+	}
+	ids := []string{strconv.Itoa(h.progID)}
+	for _, id := range h.clones {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return fmt.Sprintf("%s (programs %s, same tag)", h.funcName, strings.Join(ids, ", "))
+}
+
+// actionName returns the label or TC_ACT_* name for an action value, or
+// ACTION_<n> for values without either.
+func actionName(key uint32) string {
+	if label, ok := actionLabels[key]; ok {
+		return label
+	}
+	return defaultActionName(key)
+}
+
+// defaultActionName returns the TC_ACT_* name for an action value, or
+// ACTION_<n> if it has none.
+func defaultActionName(key uint32) string {
 	for _, action := range tcKeyOrder {
-		key := tcKeys[action]
-		var value uint64
-		if err := ebpfMap.Lookup(&key, &value); err != nil {
-			log.Printf("Error looking up %s: %v", action, err)
+		if tcKeys[action] == key {
+			return action
+		}
+	}
+	return fmt.Sprintf("ACTION_%d", key)
+}
+
+// actionTotals returns the counter of every action summed over the
+// displayed hooks, leaving out nested ones.
+func actionTotals(hooks []*fexitHook) map[uint32]uint64 {
+	totals := make(map[uint32]uint64)
+	for _, h := range hooks {
+		if h.cloneOf != nil || h.nested {
+			continue
+		}
+		counts, _, err := actionCounts(h.obj.TcActionCountMap)
+		if err != nil {
+			warnLog.Printf("Error reading action counters: %v", err)
 			continue
 		}
-		prev := prevValues[action]
- 		prevValues[action] = value
- 		rate := float64(value-prev) / deltaTime
- 		fmt.Printf("%s: %d (Rate: %.2f/s)\n", action, value, rate)
+		for key, value := range counts {
+			totals[key] += value
+		}
 	}
-	*prevTime = now
+	return totals
+}
+
+// totalCount sums every action counter of the displayed hooks.
+func totalCount(hooks []*fexitHook) uint64 {
+	var total uint64
+	for _, value := range actionTotals(hooks) {
+		total += value
+	}
+	return total
 }
 
 func main() {
-	var tcProgID int
-	pflag.IntVarP(&tcProgID, "tc-program-id", "i", 0, "TC program ID to trace")
+	os.Exit(run())
+}
+
+// run is the body of main. It returns the process exit code so that
+// deferred cleanup runs before exiting.
+func run() int {
+	var tcProgIDArg string
+	var attachAllFuncs bool
+	var pprofAddr string
+	var byFamily bool
+	var templateText string
+	var historySize int
+	var recordPath string
+	var recordInterval time.Duration
+	var recordSize int
+	var watchdogInterval time.Duration
+	var envHeader bool
+	var showVersion bool
+	var cookie uint64
+	var namePrefix string
+	var quiet bool
+	var selfTest bool
+	var probe string
+	var maxPrograms int
+	var icmpDetail bool
+	var labelsPath string
+	var categorize string
+	var sortBy string
+	var alertHigh, alertLow []string
+	var dumpMap bool
+	var progFD int
+	var warnInterval time.Duration
+	var monitorFreplace bool
+	var tripOn string
+	var tripExit bool
+	var ifaceName string
+	var simulate bool
+	var mergeClones bool
+	var textFile string
+	var bpffsRoot string
+	var requireTraffic bool
+	var topPorts int
+	var attachTimeout time.Duration
+	var attachFuncRegex string
+	var auto bool
+	var classidArg string
+	var templateFile string
+	var webAddr string
+	var excludeFromTotal []string
+	var flushInterval time.Duration
+	var byCPU bool
+	var exitIf string
+	var graphiteAddr, graphitePrefix string
+	var statsdAddr, statsdPrefix string
+	var sparklineSize int
+	var progName string
+	var selectIndex int
+	var allMatches bool
+	var selfStats bool
+	var enableStats bool
+	var since time.Duration
+	var noClear bool
+	var clearSeq string
+	var logFormat string
+	var stateFile string
+	var byCast bool
+	var byMark int
+	var ttlDist bool
+	var withClassid int
+	var linkID int
+	var readMap string
+	var pinMap string
+	var expect []string
+	var warmup time.Duration
+	var showMaps bool
+	var pinnedProg string
+	var resetOnReload bool
+	var baseline bool
+	var strict bool
+	var jsonStreamTo string
+	var jsonStreamHeaders []string
+	var jsonStreamBatch int
+	pflag.StringVarP(&tcProgIDArg, "tc-program-id", "i", "", "TC program ID to trace, or - to read IDs from stdin, one per line")
+	pflag.IntVar(&progFD, "prog-fd", -1, "Trace the TC program behind this file descriptor inherited from the parent process")
+	pflag.StringVar(&pinnedProg, "pinned-prog", "", "Trace the TC program pinned at this path, following it to the new program when the pin is replaced")
+	pflag.BoolVar(&strict, "strict", false, "Exit if --pinned-prog switches to a program that cannot be traced (code 4 if it is not a TC program, 5 if it has no BTF), instead of skipping it")
+	pflag.BoolVar(&baseline, "baseline", false, "Show counts relative to those at startup, without resetting the map; negative if it is reset meanwhile")
+	pflag.BoolVar(&resetOnReload, "reset-on-reload", false, "Start counting from zero when --pinned-prog switches to a new program, instead of carrying counters over")
+	pflag.IntVar(&linkID, "link-id", 0, "Trace the TC program behind this BPF link ID, as listed by bpftool link")
+	pflag.StringVar(&bpffsRoot, "scan-bpffs", "", "Trace every TC program pinned under /sys/fs/bpf, or under another directory with --scan-bpffs=DIR")
+	pflag.Lookup("scan-bpffs").NoOptDefVal = "/sys/fs/bpf"
+	pflag.StringVar(&progName, "name", "", "Trace the TC program with this name")
+	pflag.IntVar(&selectIndex, "select-index", -1, "When several programs match --name, trace the one at this index (0 is the oldest)")
+	pflag.BoolVar(&allMatches, "all-matches", false, "When several programs match --name, trace all of them")
+	pflag.StringVar(&namePrefix, "name-prefix", "", "Trace every TC program whose name starts with this prefix")
+	pflag.StringVar(&classidArg, "classid", "", "Trace the BPF programs that root qdisc filters run for this class ID (e.g. 1:10), as cls_bpf classifier or act_bpf action, on the interfaces that are up")
+	pflag.BoolVar(&auto, "auto", false, "Trace every TC program attached (through TCX or a clsact qdisc) to an interface that is up, labelled with the interface and direction")
+	pflag.DurationVar(&since, "since", 0, "In discovery modes (--name, --name-prefix, --scan-bpffs, --auto), only trace programs loaded within this duration")
+	pflag.IntVar(&maxPrograms, "max-programs", 64, "Maximum number of programs to trace in discovery modes such as --name-prefix (0 for no limit)")
+	pflag.BoolVar(&monitorFreplace, "monitor-freplace", false, "Also trace freplace extension programs attached to the traced programs")
+	pflag.BoolVar(&mergeClones, "merge-clones", true, "Show programs with the same tag (identical bytecode) as one unit with summed counters")
+	pflag.BoolVar(&attachAllFuncs, "attach-all-funcs", false, "Hook every BTF function of the TC program, not just the entry function")
+	pflag.StringVar(&attachFuncRegex, "attach-func-regex", "", "Hook every BTF function of the TC program whose name matches this regular expression")
+	pflag.StringVar(&graphiteAddr, "graphite-addr", "", "Send the action counts to this Graphite server (host:port) every refresh, using the plaintext protocol")
+	pflag.StringVar(&graphitePrefix, "graphite-prefix", "tcmonitor", "Prefix of the Graphite metric names")
+	pflag.StringVar(&statsdAddr, "statsd-addr", "", "Send a counter and a rate gauge per action to this StatsD collector (host:port) over UDP every refresh")
+	pflag.StringVar(&statsdPrefix, "statsd-prefix", "tcmonitor", "Prefix of the StatsD metric names")
+	pflag.StringVar(&jsonStreamTo, "json-stream-to", "", "POST the snapshots as newline-delimited JSON to this URL")
+	pflag.StringArrayVar(&jsonStreamHeaders, "json-stream-header", nil, `Extra header for --json-stream-to, e.g. "Authorization: Bearer TOKEN" (repeatable)`)
+	pflag.IntVar(&jsonStreamBatch, "json-stream-batch", 1, "Number of refreshes to send in one --json-stream-to request")
+	pflag.StringVar(&webAddr, "web-addr", "", "Serve a dashboard with a bar chart of the action counts on this address (e.g. localhost:8080 or unix:/run/tcmonitor.sock)")
+	pflag.StringVar(&pprofAddr, "pprof-addr", "", "Serve net/http/pprof on this address (e.g. localhost:6060 or unix:/run/tcmonitor-pprof.sock)")
+	pflag.BoolVar(&byFamily, "by-family", false, "Show an IPv4/IPv6/OTHER breakdown per action")
+	pflag.BoolVar(&byCast, "by-cast", false, "Show a unicast/multicast/broadcast breakdown per action, from the destination MAC address")
+	pflag.IntVar(&byMark, "by-mark", 0, "Show the N most frequent skb marks per action, as set when the TC program returns")
+	pflag.IntVar(&withClassid, "with-classid", 0, "Show the N most frequent class IDs per action, as set by direct-action classifiers through skb->tc_classid")
+	pflag.BoolVar(&ttlDist, "ttl-dist", false, "Show the distribution of IPv4 TTLs and IPv6 hop limits per action, to spot spoofing or routing loops")
+	pflag.BoolVar(&byCPU, "by-cpu", false, "Show how many packets each CPU handled, to spot RSS/RPS imbalance")
+	pflag.BoolVar(&icmpDetail, "icmp-detail", false, "Show the ICMP/ICMPv6 type and code of dropped (TC_ACT_SHOT) packets")
+	pflag.StringVar(&sortBy, "sort-by", "id", "Order of the per-program sections and JSON arrays: id, label, where (interface, with --auto) or none")
+	pflag.StringVar(&categorize, "categorize", "", `Show the totals of the PASS/DROP/REDIRECT/OTHER categories instead of every action, or next to them with "both"`)
+	pflag.Lookup("categorize").NoOptDefVal = "only"
+	pflag.StringVar(&labelsPath, "labels", "", "File of ACTION=label lines renaming actions in the output; reloaded when it changes")
+	pflag.StringSliceVar(&alertHigh, "alert-high", nil, "ACTION=rate: log FIRING once the action's rate (per second) reaches this value")
+	pflag.StringSliceVar(&alertLow, "alert-low", nil, "ACTION=rate: log RESOLVED only once a firing action's rate drops below this value (defaults to --alert-high)")
+	pflag.StringVar(&exitIf, "exit-if", "", `Exit with code 10 on shutdown if this condition on the final counts holds, e.g. "SHOT>100" or "TOTAL==0"`)
+	pflag.StringArrayVar(&expect, "expect", nil, `Exit with code 11 on shutdown unless an action's share of the total meets this, e.g. "OK>=99%" (repeatable)`)
+	pflag.BoolVar(&requireTraffic, "require-traffic", false, "Exit with a non-zero code on shutdown if no packets were classified at all")
+	pflag.StringVar(&tripOn, "trip-on", "", "Log TRIPPED as soon as this action occurs (e.g. TC_ACT_TRAP)")
+	pflag.BoolVar(&tripExit, "trip-exit", false, "Exit with a non-zero code when --trip-on trips")
+	pflag.StringVar(&ifaceName, "iface-stats", "", "Show this interface's rx/tx packet counters next to the TC totals")
+	pflag.StringVar(&textFile, "text-file", "", "Also write the stats table to this file on every refresh, replacing it atomically")
+	pflag.StringVar(&stateFile, "state-file", "", "Write the latest snapshot of every hook as JSON to this file on every refresh, replacing it atomically")
+	pflag.DurationVar(&flushInterval, "flush-interval", 0, "Write --text-file and --state-file at most this often instead of on every refresh (0 for every refresh)")
+	pflag.DurationVar(&attachTimeout, "attach-timeout", 5*time.Second, "Give up if loading and attaching the fexit programs takes longer than this (0 to wait forever)")
+	pflag.IntVar(&topPorts, "top-ports", 0, "Show the N busiest TCP/UDP destination ports per action")
+	pflag.StringSliceVar(&excludeFromTotal, "exclude-from-total", nil, "Actions still listed but left out of the total and the percentages (e.g. TC_ACT_OK)")
+	pflag.StringVar(&templateText, "template", "", `Go text/template rendered for every refresh instead of the stats table.
+Fields: .ProgramID, .Function, .Where, .Timestamp, .Interval, .Actions (name -> .Code, .Count, .Rate, .Percent), .Total.
+Examples:
+  '{{.Timestamp.Unix}} {{.Total}}'
+  '{{range $name, $a := .Actions}}{{$name}}={{$a.Count}} {{end}}'`)
+	pflag.StringVar(&templateFile, "template-file", "", "Like --template, but read the template from this file; reloaded when it changes")
+	pflag.IntVar(&sparklineSize, "sparkline", 0, "Show a sparkline of each action's rate over the last N refreshes")
+	pflag.StringVar(&recordPath, "record", "", "Sample the counters every --record-interval into an in-memory ring and write it to this CSV file on exit or SIGUSR1")
+	pflag.DurationVar(&recordInterval, "record-interval", 10*time.Millisecond, "Sampling interval of --record")
+	pflag.IntVar(&recordSize, "record-size", 60000, "Number of samples (per hook) kept by --record; older ones are dropped")
+	pflag.IntVar(&historySize, "history", 0, "Keep the last N refreshes in memory and print them on exit or SIGUSR1")
+	pflag.DurationVar(&watchdogInterval, "watchdog-interval", 5*time.Second, "How often to check that the fexit link is still attached and re-attach it if not (0 disables)")
+	pflag.BoolVar(&envHeader, "env-header", false, "Show kernel version, architecture, BTF availability and tcmonitor version above the output")
+	pflag.Uint64Var(&cookie, "cookie", defaultCookie, "BPF cookie set on the fexit link to identify it in bpftool link output (0 disables; left out on kernels before 6.0, which do not support it)")
+	pflag.BoolVar(&noClear, "no-clear", false, "Append every refresh instead of clearing the screen")
+	pflag.StringVar(&clearSeq, "clear-sequence", "", "Printed before every refresh instead of the ANSI clear sequence; by default none is printed if $TERM is dumb or unset")
+	pflag.DurationVar(&warmup, "warmup", 0, "Show counts only, without rates, for this long after starting; the first refresh never has rates")
+	pflag.IntVar(&precision, "precision", 2, "Decimals shown for rates and percentages")
+	pflag.IntVar(&minColumnWidth, "min-width", 0, "Minimum width of the count and rate columns, so the table does not shift as counts grow")
+	pflag.BoolVar(&groupDigits, "group-digits", false, "Separate thousands in the counts of the table (machine-readable outputs keep plain numbers)")
+	pflag.BoolVarP(&quiet, "quiet", "q", false, "Do not print the stats table or template output; other outputs such as --history stay active")
+	pflag.StringVar(&logFormat, "log-format", "text", "Format of the diagnostic log on stderr: text or json")
+	pflag.DurationVar(&warnInterval, "warn-interval", time.Minute, "Log a repeated identical warning at most once per interval")
+	pflag.BoolVar(&showMaps, "show-maps", false, "At startup, list the maps used by every traced program")
+	pflag.BoolVar(&dumpMap, "dump-map", false, "Print the raw contents of tcmonitor's maps after one refresh interval and exit")
+	pflag.StringVar(&pinMap, "pin-map", "", "Pin the action map of the traced program at this bpffs path while running, for --read-map")
+	pflag.StringVar(&readMap, "read-map", "", "Display the action counters of another tcmonitor from this pinned map, without attaching anything")
+	pflag.BoolVar(&simulate, "simulate", false, "DEMO ONLY: show synthetic, randomly increasing counters instead of tracing a program")
+	pflag.BoolVar(&selfStats, "self-stats", false, "Show the memory used by tcmonitor's own maps, the number of links it holds and the run time of its fexit programs")
+	pflag.BoolVar(&enableStats, "enable-bpf-stats", false, "Enable the kernel's BPF run time statistics while running, without setting kernel.bpf_stats_enabled (default on with --self-stats)")
+	pflag.StringVar(&probe, "probe", "", `Report which kernel features tcmonitor can use on this host and exit; "json" prints them as JSON`)
+	pflag.Lookup("probe").NoOptDefVal = "text"
+	pflag.BoolVar(&selfTest, "self-test", false, "Check that tcmonitor's BPF objects load on this kernel, without attaching, and exit")
+	pflag.BoolVar(&showVersion, "version", false, "Print version information and exit")
 	pflag.Parse()
 
-	if tcProgID == 0 {
-		log.Fatal("You need to specify a valid TC Program ID.")
+	if showVersion {
+		fmt.Println(versionString())
+		return 0
+	}
+
+	if tcProgIDArg == "" && pinnedProg == "" && linkID == 0 && progFD < 0 && progName == "" && namePrefix == "" && bpffsRoot == "" && !auto && classidArg == "" && readMap == "" && !selfTest && !simulate && probe == "" {
+		fmt.Fprintln(os.Stderr, "No TC program to trace. Pass its ID with -i or --link-id, its pin with --pinned-prog, or let tcmonitor find programs with --auto, --classid, --name, --name-prefix or --scan-bpffs.")
+		fmt.Fprintln(os.Stderr, "Run `bpftool prog show` to list the loaded programs.")
+		fmt.Fprintln(os.Stderr)
+		pflag.Usage()
+		return exitUsage
+	}
+
+	if precision < 0 {
+		return failed(usagef("--precision must not be negative"))
+	}
+	if recordPath != "" && (recordInterval <= 0 || recordSize <= 0) {
+		return failed(usagef("--record-interval and --record-size must be positive"))
+	}
+	if readMap != "" && (byFamily || byCast || byMark > 0 || icmpDetail || topPorts > 0 || ttlDist || withClassid > 0) {
+		return failed(usagef("--read-map only shares the action map; breakdowns such as --by-family are not available with it"))
+	}
+	var classid uint32
+	if classidArg != "" {
+		var err error
+		if classid, err = parseClassid(classidArg); err != nil {
+			return failed(usagef("Invalid --classid: %v", err))
+		}
+	}
+	if err := sortHooks(nil, sortBy); err != nil {
+		return failed(usagef("Invalid --sort-by: %v", err))
+	}
+	if categorize != "" && categorize != "only" && categorize != "both" {
+		return failed(usagef("Invalid --categorize %q: expected only or both", categorize))
+	}
+	if err := setupLogging(logFormat); err != nil {
+		return failed(usagef("Invalid --log-format: %v", err))
+	}
+	warnLog = newRateLimitedLogger(warnInterval)
+
+	if probe != "" {
+		if probe != "text" && probe != "json" {
+			return failed(usagef("Invalid --probe %q: expected text or json", probe))
+		}
+		if err := rlimit.RemoveMemlock(); err != nil {
+			log.Printf("Failed to remove rlimit memlock: %v", err)
+		}
+		if err := writeProbeResults(os.Stdout, probeFeatures(), probe); err != nil {
+			return failed(err)
+		}
+		return 0
+	}
+
+	var tmpl *template.Template
+	if templateText != "" {
+		var err error
+		if tmpl, err = parseTemplate(templateText); err != nil {
+			return failed(usagef("Invalid --template: %v", err))
+		}
+	}
+
+	var templateEvents chan fsnotify.Event
+	var templateErrors chan error
+	if templateFile != "" {
+		if templateText != "" {
+			return failed(usagef("--template and --template-file are mutually exclusive"))
+		}
+		var err error
+		if tmpl, err = loadTemplate(templateFile); err != nil {
+			return failed(usagef("Invalid --template-file: %v", err))
+		}
+
+		watcher, err := watchFile(templateFile)
+		if err != nil {
+			return failf("Failed to watch template file: %v", err)
+		}
+		defer watcher.Close()
+		templateEvents, templateErrors = watcher.Events, watcher.Errors
+	}
+
+	var labelEvents chan fsnotify.Event
+	var labelErrors chan error
+	if labelsPath != "" {
+		labels, categories, err := loadLabels(labelsPath)
+		if err != nil {
+			return failed(usagef("Invalid --labels: %v", err))
+		}
+		actionLabels, categoryOverrides = labels, categories
+
+		watcher, err := watchFile(labelsPath)
+		if err != nil {
+			return failf("Failed to watch labels file: %v", err)
+		}
+		defer watcher.Close()
+		labelEvents, labelErrors = watcher.Events, watcher.Errors
+	}
+
+	alertRules, err := parseAlertRules(alertHigh, alertLow)
+	if err != nil {
+		return failed(usagef("Invalid alert thresholds: %v", err))
+	}
+
+	var funcRegex *regexp.Regexp
+	switch {
+	case attachFuncRegex != "":
+		if funcRegex, err = regexp.Compile(attachFuncRegex); err != nil {
+			return failed(usagef("Invalid --attach-func-regex: %v", err))
+		}
+	case attachAllFuncs:
+		funcRegex = regexp.MustCompile("")
+	}
+
+	var ifStats *ifaceStats
+	if ifaceName != "" {
+		if ifStats, err = newIfaceStats(ifaceName); err != nil {
+			return failed(usagef("Invalid --iface-stats: %v", err))
+		}
+	}
+
+	var tripAction *uint32
+	var exitCond *comparison
+	if exitIf != "" {
+		var err error
+		if exitCond, err = parseExitCondition(exitIf); err != nil {
+			return failed(usagef("Invalid --exit-if: %v", err))
+		}
+	}
+
+	var expectations []*comparison
+	for _, expr := range expect {
+		c, err := parseExpectation(expr)
+		if err != nil {
+			return failed(usagef("Invalid --expect: %v", err))
+		}
+		expectations = append(expectations, c)
+	}
+
+	for _, action := range excludeFromTotal {
+		key, err := parseAction(action)
+		if err != nil {
+			return failed(usagef("Invalid --exclude-from-total: %v", err))
+		}
+		excludedFromTotal[key] = true
+	}
+
+	if tripOn != "" {
+		key, err := parseAction(tripOn)
+		if err != nil {
+			return failed(usagef("Invalid --trip-on: %v", err))
+		}
+		tripAction = &key
+	}
+
+	if pprofAddr != "" {
+		l, err := serveHTTP("pprof", pprofAddr, http.DefaultServeMux)
+		if err != nil {
+			return failed(err)
+		}
+		defer l.Close()
+	}
+
+	var web *dashboard
+	if webAddr != "" {
+		web = new(dashboard)
+		l, err := web.serve(webAddr)
+		if err != nil {
+			return failed(err)
+		}
+		defer l.Close()
+	}
+
+	if selfStats && !pflag.CommandLine.Changed("enable-bpf-stats") {
+		enableStats = true
+	}
+	if enableStats {
+		stats, err := enableBPFStats()
+		if err != nil {
+			log.Printf("Failed to enable BPF statistics (needs Linux 5.8): %v", err)
+		} else {
+			defer stats.Close()
+		}
+	}
+
+	var statsd *statsdSink
+	if statsdAddr != "" {
+		var err error
+		if statsd, err = newStatsdSink(statsdAddr, statsdPrefix); err != nil {
+			log.Printf("Not sending to StatsD: %v", err)
+		} else {
+			defer statsd.close()
+		}
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	var graphite *graphiteSink
+	if graphiteAddr != "" {
+		graphite = newGraphiteSink(ctx, graphiteAddr, graphitePrefix)
+	}
+
+	var stream *httpSink
+	if jsonStreamTo != "" {
+		var err error
+		if stream, err = newHTTPSink(ctx, jsonStreamTo, jsonStreamHeaders, jsonStreamBatch); err != nil {
+			return failed(usagef("Invalid --json-stream-header: %v", err))
+		}
+	}
+
 	if err := rlimit.RemoveMemlock(); err != nil {
-		log.Fatalf("Failed to remove rlimit memlock: %v", err)
+		return failf("Failed to remove rlimit memlock: %v", err)
 	}
 
 	spec, err := loadTcmonitor()
 	if err != nil {
-		log.Fatalf("Failed to load tcmonitor BPF spec: %v", err)
+		return failf("Failed to load tcmonitor BPF spec: %v", err)
 	}
 
-	tcProg, err := ebpf.NewProgramFromID(ebpf.ProgramID(tcProgID))
-	if err != nil {
-		log.Fatalf("Failed to load TC program ID %d: %v", tcProgID, err)
+	if selfTest {
+		if !runSelfTest(spec) {
+			return exitFailure
+		}
+		return 0
 	}
-	defer tcProg.Close()
 
-	tcFuncName, err := getFuncName(tcProg)
-	if err != nil {
-		log.Fatalf("Failed to get function name: %v", err)
+	for name, enabled := range map[string]bool{
+		"by_family":    byFamily,
+		"icmp_detail":  icmpDetail,
+		"top_ports":    topPorts > 0,
+		"by_cast":      byCast,
+		"by_mark":      byMark > 0,
+		"ttl_dist":     ttlDist,
+		"with_classid": withClassid > 0,
+	} {
+		if !enabled {
+			continue
+		}
+		if err := spec.Variables[name].Set(true); err != nil {
+			return failf("Failed to enable %s: %v", name, err)
+		}
 	}
 
-	tcFexit := spec.Programs["fexit_tc"]
-	tcFexit.AttachTarget = tcProg
-	tcFexit.AttachTo = tcFuncName
+	var targets []*ebpf.Program
+	if tcProgIDArg != "" {
+		ids, err := parseProgramIDs(tcProgIDArg, os.Stdin)
+		if err != nil {
+			return failed(usagef("Invalid --tc-program-id: %v", err))
+		}
+		for _, id := range ids {
+			tcProg, err := loadProgram(id)
+			if err != nil {
+				return failed(err)
+			}
+			targets = append(targets, tcProg)
+		}
+	}
+	var pinnedProgID int
+	if pinnedProg != "" {
+		tcProg, err := loadPinnedTCProgram(pinnedProg)
+		if err != nil {
+			return failed(err)
+		}
+		pinnedProgID = programID(tcProg)
+		targets = append(targets, tcProg)
+	}
+	if linkID != 0 {
+		tcProg, err := programFromLink(linkID)
+		if err != nil {
+			return failed(err)
+		}
+		targets = append(targets, tcProg)
+	}
+	if progFD >= 0 {
+		tcProg, err := ebpf.NewProgramFromFD(progFD)
+		if err != nil {
+			return failf("Failed to open program from FD %d: %v", progFD, err)
+		}
+		targets = append(targets, tcProg)
+	}
+	where := make(map[int]string)
+	if classidArg != "" {
+		progs, classidWhere, err := classidTargets(classid)
+		if err != nil {
+			return failed(err)
+		}
+		targets = append(targets, progs...)
+		maps.Copy(where, classidWhere)
+	}
+	// Programs found by discovery are capped with --max-programs. Those
+	// named explicitly above are always traced.
+	var discovered []*ebpf.Program
+	if progName != "" {
+		progs, err := findProgramsByName(progName)
+		if err != nil {
+			return failf("Failed to find TC programs called %q: %v", progName, err)
+		}
+		progs = loadedWithin(progs, since)
+		if progs, err = selectByName(progName, progs, selectIndex, allMatches); err != nil {
+			return failed(err)
+		}
+		discovered = append(discovered, progs...)
+	}
+	if namePrefix != "" {
+		progs, err := findProgramsByPrefix(namePrefix)
+		if err != nil {
+			return failf("Failed to find TC programs with prefix %q: %v", namePrefix, err)
+		}
+		discovered = append(discovered, loadedWithin(progs, since)...)
+	}
+	if bpffsRoot != "" {
+		progs, err := scanBPFFS(bpffsRoot)
+		if err != nil {
+			return failf("Failed to scan %s: %v", bpffsRoot, err)
+		}
+		discovered = append(discovered, loadedWithin(progs, since)...)
+	}
+	if auto {
+		progs, autoWhere, err := autoTargets(since)
+		if err != nil {
+			return failf("Failed to discover TC programs: %v", err)
+		}
+		discovered = append(discovered, progs...)
+		maps.Copy(where, autoWhere)
+	}
+	targets = append(targets, limitPrograms(dedupPrograms(discovered), maxPrograms)...)
+	for _, tcProg := range targets {
+		exts, err := findExtensions(tcProg)
+		if err != nil {
+			log.Printf("Failed to look for freplace extensions: %v", err)
+			break
+		}
+		for _, ext := range exts {
+			fmt.Printf("Program %d is extended by freplace program %d, which runs instead of the replaced function\n", programID(tcProg), programID(ext))
+			if !monitorFreplace {
+				fmt.Println("Use --monitor-freplace to trace the extension as well")
+				ext.Close()
+				continue
+			}
+			targets = append(targets, ext)
+		}
+	}
+	targets = dedupPrograms(targets)
+	if len(targets) == 0 && !simulate && readMap == "" {
+		// The command line was valid, the selectors just matched nothing.
+		log.Print("No TC programs found to trace")
+		return exitProgramNotFound
+	}
+	defer closePrograms(targets)
 
-	var obj tcmonitorObjects
-	if err := spec.LoadAndAssign(&obj, nil); err != nil {
-		if ve := new(ebpf.VerifierError); errors.As(err, &ve) {
-			log.Fatalf("Failed to load BPF object: %v\nVerifier log:\n%v", err, ve)
+	if len(targets) > 0 {
+		if err := checkFexitSupport(); err != nil {
+			return failed(err)
 		}
-		log.Fatalf("Failed to load BPF object: %v", err)
 	}
-	defer obj.Close()
 
-	tcfexit, err := link.AttachTracing(link.TracingOptions{
-		Program: obj.FexitTc,
+	var leaders map[string]*fexitHook
+	if mergeClones {
+		leaders = make(map[string]*fexitHook)
+	}
+
+	var hooks []*fexitHook
+	var attached []*ebpf.Program
+	var failures []error
+	err = withTimeout(attachTimeout, func() error {
+		for _, tcProg := range targets {
+			progHooks, err := hookProgram(spec, tcProg, funcRegex, cookie, leaders)
+			if err != nil && len(targets) == 1 {
+				return err
+			}
+			if err != nil {
+				failures = append(failures, err)
+				continue
+			}
+			attached = append(attached, tcProg)
+			for _, h := range progHooks {
+				h.where = where[h.progID]
+				if pinnedProg != "" && h.progID == pinnedProgID {
+					h.pinPath = pinnedProg
+				}
+				if historySize > 0 {
+					h.history = newSnapshotRing(historySize)
+				}
+			}
+			hooks = append(hooks, progHooks...)
+		}
+		return nil
 	})
+	if errors.Is(err, errTimeout) {
+		return failf("Loading and attaching fexit programs did not finish within %v (see --attach-timeout)", attachTimeout)
+	}
 	if err != nil {
-		log.Fatalf("Failed to attach fexit program: %v", err)
+		return failed(err)
+	}
+	if len(failures) > 0 {
+		fmt.Printf("Attached to %d of %d programs, %d failed:\n", len(attached), len(targets), len(failures))
+		for _, err := range failures {
+			fmt.Printf("  %v\n", err)
+		}
+		if len(attached) == 0 && !simulate {
+			return failed(failures[0])
+		}
+	}
+	sortHooks(hooks, sortBy)
+	if nested := markNested(hooks); len(nested) > 0 {
+		fmt.Println("Shared attach targets, shown separately but left out of the totals to avoid counting packets twice:")
+		for _, line := range nested {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if readMap != "" {
+		h, err := newReadOnlyHook(spec, readMap)
+		if err != nil {
+			return failf("Invalid --read-map: %v", err)
+		}
+		if historySize > 0 {
+			h.history = newSnapshotRing(historySize)
+		}
+		hooks = append(hooks, h)
+		fmt.Printf("Reading counters from %s, no TC program is traced\n", readMap)
+	}
+	if simulate {
+		h, err := newSimulatedHook(spec)
+		if err != nil {
+			return failf("Failed to set up simulation: %v", err)
+		}
+		if historySize > 0 {
+			h.history = newSnapshotRing(historySize)
+		}
+		hooks = append(hooks, h)
+		fmt.Println("SIMULATION MODE: showing synthetic counters, no TC program is traced")
+	}
+
+	defer func() {
+		for _, h := range hooks {
+			if h.link != nil {
+				h.link.Close()
+			}
+			h.obj.Close()
+			if h.ownsProg {
+				h.prog.Close()
+			}
+		}
+	}()
+
+	if pinMap != "" {
+		if len(hooks) != 1 || hooks[0].prog == nil {
+			return failed(usagef("--pin-map needs exactly one traced function"))
+		}
+		m := hooks[0].obj.TcActionCountMap
+		if err := replacePin(spec, m, pinMap); err != nil {
+			return failf("Failed to pin action map: %v", err)
+		}
+		defer m.Unpin()
+	}
+
+	if baseline {
+		for _, h := range hooks {
+			if h.cloneOf != nil {
+				continue
+			}
+			counts, _, err := actionCounts(h.obj.TcActionCountMap)
+			if err != nil {
+				log.Printf("Failed to read --baseline counts of %s: %v", h.label(), err)
+				return exitFailure
+			}
+			h.baseline = counts
+		}
+	}
+
+	var rec *recorder
+	var recordTick <-chan time.Time
+	if recordPath != "" {
+		rec = newRecorder(recordPath, recordSize*len(hooks))
+		recordTicker := time.NewTicker(recordInterval)
+		defer recordTicker.Stop()
+		recordTick = recordTicker.C
+	}
+
+	printHistory := func() {
+		for _, h := range hooks {
+			if h.history != nil && h.cloneOf == nil {
+				writeHistory(os.Stdout, h.label(), h.history)
+			}
+		}
+		if rec != nil {
+			if err := rec.dump(); err != nil {
+				log.Printf("Failed to write --record file: %v", err)
+			} else {
+				log.Printf("Wrote recorded counters to %s", rec.path)
+			}
+		}
+	}
+
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+
+	// The labels and template files are reloaded when they change, and on
+	// SIGHUP. Files that fail to parse leave the previous contents in use.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	reloadLabels := func() {
+		if labelsPath == "" {
+			return
+		}
+		labels, categories, err := loadLabels(labelsPath)
+		if err != nil {
+			log.Printf("Keeping previous labels: %v", err)
+			return
+		}
+		actionLabels, categoryOverrides = labels, categories
+	}
+	reloadTemplate := func() {
+		if templateFile == "" {
+			return
+		}
+		t, err := loadTemplate(templateFile)
+		if err != nil {
+			log.Printf("Keeping previous template: %v", err)
+			return
+		}
+		tmpl = t
+	}
+
+	var header string
+	if envHeader {
+		header = environmentHeader()
+		fmt.Print(header)
+	}
+
+	for _, prog := range attached {
+		if tracingCookies {
+			fmt.Printf("Tracing TC Program with ID %d (link cookie %#x)...\n", programID(prog), cookie)
+		} else {
+			fmt.Printf("Tracing TC Program with ID %d...\n", programID(prog))
+		}
+		if showMaps {
+			printProgramMaps(os.Stdout, prog)
+		}
+	}
+	for _, h := range hooks {
+		if h.prog == nil || h.cloneOf != nil {
+			continue
+		}
+		if jsonLogs {
+			// Text logs already have the Tracing line above on stdout.
+			h.logEvent("attach", "Attached to %s", h.label())
+		}
+		if source := funcSource(h.prog, h.funcName); source != "" {
+			fmt.Printf("Hooked %s of program %d, defined at %s\n", h.funcName, h.progID, source)
+		}
 	}
-	defer tcfexit.Close()
 
-	fmt.Printf("Tracing TC Program with ID %d...\n", tcProgID)
+	clearScreen := clearSequence(clearSeq, pflag.CommandLine.Changed("clear-sequence"))
+	if noClear {
+		clearScreen = ""
+	}
 
+	warmupUntil = time.Now().Add(warmup)
 	ticker := time.NewTicker(1 * time.Second)
+	var lastFlush time.Time
 	defer ticker.Stop()
 
- 	prevValues := make(map[string]uint64)
- 	prevTime := time.Now()
+	var watchdog <-chan time.Time
+	if watchdogInterval > 0 {
+		watchdogTicker := time.NewTicker(watchdogInterval)
+		defer watchdogTicker.Stop()
+		watchdog = watchdogTicker.C
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
+			printHistory()
 			fmt.Println("\nExiting...")
-			return
+			if requireTraffic && totalCount(hooks) == 0 {
+				log.Print("No packets were classified by the traced programs (--require-traffic)")
+				return exitNoTraffic
+			}
+			if failed := checkExpectations(expectations, actionTotals(hooks)); len(failed) > 0 {
+				for _, msg := range failed {
+					log.Printf("Expectation failed: %s", msg)
+				}
+				return exitExpectationFailed
+			}
+			if exitCond != nil && exitConditionHolds(exitCond, actionTotals(hooks)) {
+				log.Printf("Exit condition %s holds", exitCond.expr)
+				return exitConditionHeld
+			}
+			return 0
+		case <-usr1:
+			printHistory()
+		case <-hup:
+			if labelsPath == "" && templateFile == "" {
+				log.Print("SIGHUP: no --labels or --template-file to reload; other settings need a restart")
+				continue
+			}
+			reloadLabels()
+			reloadTemplate()
+			log.Print("SIGHUP: reloaded files; other settings need a restart")
+		case <-recordTick:
+			rec.sample(hooks)
+		case ev := <-labelEvents:
+			if !isFileChange(ev, labelsPath) {
+				continue
+			}
+			reloadLabels()
+		case err := <-labelErrors:
+			log.Printf("Error watching labels file: %v", err)
+		case ev := <-templateEvents:
+			if !isFileChange(ev, templateFile) {
+				continue
+			}
+			reloadTemplate()
+		case err := <-templateErrors:
+			log.Printf("Error watching template file: %v", err)
+		case <-watchdog:
+			for _, h := range hooks {
+				if err := h.followPin(spec, resetOnReload); err != nil {
+					if strict {
+						log.Print(err)
+						printHistory()
+						return exitCode(err)
+					}
+					warnLog.Printf("%v, still tracing program %d", err, h.progID)
+				}
+				h.checkLink(spec, watchdogInterval)
+			}
 		case <-ticker.C:
-			fmt.Print("\033[H\033[J") // Clear screen
-			lookupAndPrintStats(obj.TcActionCountMap, prevValues, &prevTime)
+			if dumpMap {
+				for _, h := range hooks {
+					dumpMaps(os.Stdout, h)
+				}
+				return 0
+			}
+			var frame bytes.Buffer
+			frame.WriteString(header)
+			var tcTotal uint64
+			var tcRate float64
+			var labeled []hookSnapshot
+			var snapshots []*Snapshot
+			for _, h := range hooks {
+				if h.cloneOf != nil {
+					continue
+				}
+				if h.simulated {
+					if err := h.simulateTraffic(); err != nil {
+						warnLog.Printf("Error simulating traffic: %v", err)
+					}
+				}
+
+				if len(hooks) > 1 {
+					fmt.Fprintf(&frame, "\n%s:", h.label())
+				}
+				fmt.Fprintln(&frame, "\nTC Actions:")
+				s := h.snapshot()
+				if s != nil {
+					if sparklineSize > 0 {
+						if h.sparks == nil {
+							h.sparks = newSparklines(sparklineSize)
+						}
+						h.sparks.record(s)
+					}
+					if categorize != "" {
+						s.writeCategories(&frame)
+					}
+					if categorize != "only" {
+						s.writeTable(&frame, h.sparks)
+					}
+				}
+				if byCPU {
+					printCPUStats(&frame, h.obj.TcActionCountMap)
+				}
+				if byFamily {
+					printFamilyStats(&frame, h.obj.TcFamilyCountMap)
+				}
+				if byCast {
+					printCastStats(&frame, h.obj.TcCastCountMap)
+				}
+				if ttlDist {
+					printTTLStats(&frame, h.obj.TcTtlCountMap)
+				}
+				if (byMark > 0 || withClassid > 0) && s != nil {
+					counts := make(map[uint32]uint64, len(s.Actions))
+					for _, a := range s.Actions {
+						counts[a.Code] = a.Count
+					}
+					if byMark > 0 {
+						printTopMarks(&frame, h.obj.TcMarkCountMap, counts, byMark)
+					}
+					if withClassid > 0 {
+						printTopClassids(&frame, h.obj.TcClassidCountMap, counts, withClassid)
+					}
+				}
+				if icmpDetail {
+					printIcmpDrops(&frame, h.obj.TcIcmpDropMap)
+				}
+				if topPorts > 0 {
+					printTopPorts(&frame, h.obj.TcPortCountMap, topPorts)
+				}
+				if s == nil {
+					continue
+				}
+				if !h.nested {
+					tcTotal += s.Total
+					tcRate += s.totalRate()
+				}
+				labeled = append(labeled, hookSnapshot{h.label(), s})
+				snapshots = append(snapshots, s)
+
+				if tmpl != nil && !quiet {
+					if err := tmpl.Execute(os.Stdout, s); err != nil {
+						warnLog.Printf("Error executing template: %v", err)
+					}
+					fmt.Println()
+				}
+				if h.history != nil {
+					h.history.add(s)
+				}
+				h.evaluateAlerts(alertRules, s)
+				if tripAction != nil && h.checkTripwire(*tripAction, s) && tripExit {
+					printHistory()
+					return exitTripped
+				}
+			}
+			if ifStats != nil {
+				ifStats.print(&frame, tcTotal, tcRate)
+			}
+			if selfStats {
+				writeSelfStats(&frame, hooks)
+			}
+			if web != nil {
+				web.update(labeled)
+			}
+			if stream != nil {
+				stream.add(labeled)
+			}
+			if graphite != nil {
+				graphite.add(snapshots)
+			}
+			if statsd != nil {
+				if err := statsd.send(snapshots); err != nil {
+					warnLog.Printf("Error sending to StatsD: %v", err)
+				}
+			}
+
+			if tmpl == nil && !quiet {
+				fmt.Print(clearScreen)
+				os.Stdout.Write(frame.Bytes())
+			}
+			if (textFile != "" || stateFile != "") && time.Since(lastFlush) >= flushInterval {
+				if textFile != "" {
+					if err := writeFileAtomic(textFile, frame.Bytes()); err != nil {
+						warnLog.Printf("Error writing --text-file: %v", err)
+					}
+				}
+				if stateFile != "" {
+					if err := writeStateFile(stateFile, labeled); err != nil {
+						warnLog.Printf("Error writing --state-file: %v", err)
+					}
+				}
+				lastFlush = time.Now()
+			}
 		}
 	}
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// markNested flags hooks whose packets are also counted by another hook, so
+// that summing across hooks would count them twice:
+//   - functions other than the entry function of a program whose entry
+//     function is hooked too, since the entry function calls them, and
+//   - freplace extensions of a traced program, since they run in place of
+//     one of its functions.
+//
+// Nested hooks are still displayed on their own but are left out of totals
+// taken across hooks. markNested returns one line per nested hook for the
+// attach summary.
+func markNested(hooks []*fexitHook) []string {
+	hooked := make(map[int]map[string]bool)
+	for _, h := range hooks {
+		if h.prog == nil {
+			continue
+		}
+		if hooked[h.progID] == nil {
+			hooked[h.progID] = make(map[string]bool)
+		}
+		hooked[h.progID][h.funcName] = true
+	}
+
+	var replaces map[ebpf.ProgramID]uint32
+	if links, err := freplaceLinks(); err == nil {
+		replaces = make(map[ebpf.ProgramID]uint32, len(links))
+		for _, l := range links {
+			replaces[l.extensionID] = l.targetID
+		}
+	}
+
+	var lines []string
+	for _, h := range hooks {
+		if h.prog == nil || h.cloneOf != nil {
+			continue
+		}
+		if target, ok := replaces[ebpf.ProgramID(h.progID)]; ok && hooked[int(target)] != nil {
+			h.nested = true
+			lines = append(lines, fmt.Sprintf("%s replaces a function of traced program %d", h.label(), target))
+			continue
+		}
+		entry, err := getFuncName(h.prog)
+		if err == nil && entry != h.funcName && hooked[h.progID][entry] {
+			h.nested = true
+			lines = append(lines, fmt.Sprintf("%s is called by %s, which is hooked too", h.label(), entry))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// sortHooks orders hooks for display by key, which is one of:
+//   - "id": program ID,
+//   - "label": the section title, so by function name first,
+//   - "where": the interface and direction from --auto, then program ID,
+//   - "none": the order the programs were found in.
+//
+// The functions of one program keep their instruction order. The order is
+// fixed at startup, so sections do not move between refreshes, not even
+// when --pinned-prog switches to a program with another ID.
+func sortHooks(hooks []*fexitHook, key string) error {
+	var less func(a, b *fexitHook) bool
+	switch key {
+	case "id":
+		less = func(a, b *fexitHook) bool { return a.progID < b.progID }
+	case "label":
+		less = func(a, b *fexitHook) bool { return a.label() < b.label() }
+	case "where":
+		less = func(a, b *fexitHook) bool {
+			if a.where != b.where {
+				return a.where < b.where
+			}
+			return a.progID < b.progID
+		}
+	case "none":
+		return nil
+	default:
+		return fmt.Errorf("unknown key %q, expected id, label, where or none", key)
+	}
+	sort.SliceStable(hooks, func(i, j int) bool { return less(hooks[i], hooks[j]) })
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortHooks(t *testing.T) {
+	newHooks := func() []*fexitHook {
+		return []*fexitHook{
+			{progID: 30, funcName: "b", where: "eth0 ingress"},
+			{progID: 10, funcName: "c", where: "eth1 egress"},
+			{progID: 20, funcName: "a", where: "eth0 ingress"},
+			{progID: 10, funcName: "a", where: "eth1 egress"},
+		}
+	}
+	type hook struct {
+		id   int
+		name string
+	}
+
+	for _, test := range []struct {
+		key  string
+		want []hook
+	}{
+		// The functions of program 10 keep their order.
+		{"id", []hook{{10, "c"}, {10, "a"}, {20, "a"}, {30, "b"}}},
+		{"label", []hook{{10, "a"}, {20, "a"}, {30, "b"}, {10, "c"}}},
+		{"where", []hook{{20, "a"}, {30, "b"}, {10, "c"}, {10, "a"}}},
+		{"none", []hook{{30, "b"}, {10, "c"}, {20, "a"}, {10, "a"}}},
+	} {
+		hooks := newHooks()
+		if err := sortHooks(hooks, test.key); err != nil {
+			t.Fatalf("%s: %v", test.key, err)
+		}
+		var got []hook
+		for _, h := range hooks {
+			got = append(got, hook{h.progID, h.funcName})
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: order = %v, want %v", test.key, got, test.want)
+		}
+	}
+
+	if err := sortHooks(nil, "name"); err == nil {
+		t.Error("unknown key: expected an error")
+	}
+}
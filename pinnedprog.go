@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// loadPinnedTCProgram opens the TC program pinned at path.
+func loadPinnedTCProgram(path string) (*ebpf.Program, error) {
+	prog, err := ebpf.LoadPinnedProgram(path, &ebpf.LoadPinOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pinned program %s: %w", path, err)
+	}
+	info, err := prog.Info()
+	if err != nil {
+		prog.Close()
+		return nil, fmt.Errorf("failed to get info of pinned program %s: %w", path, err)
+	}
+	if info.Type != ebpf.SchedCLS && info.Type != ebpf.SchedACT {
+		prog.Close()
+		return nil, fmt.Errorf("pinned program %s has type %s: %w", path, info.Type, ErrNotTCProgram)
+	}
+	return prog, nil
+}
+
+// followPin re-attaches h if its pin path now holds a different program,
+// as after an atomic replace of the pinned program. Counters carry over
+// unless reset is set. A hook on the entry function follows it even if it
+// was renamed; other hooks need a function of the same name.
+//
+// If the new program cannot be traced, because it is not a TC program,
+// has no BTF or lacks the function, followPin returns an error and keeps
+// tracing the old program. Other failures are only logged and retried
+// next time.
+func (h *fexitHook) followPin(spec *ebpf.CollectionSpec, reset bool) error {
+	if h.pinPath == "" || h.cloneOf != nil {
+		return nil
+	}
+	prog, err := loadPinnedTCProgram(h.pinPath)
+	if errors.Is(err, ErrNotTCProgram) {
+		return fmt.Errorf("refusing to follow %s: %w", h.pinPath, err)
+	}
+	if err != nil {
+		warnLog.Printf("Not following %s: %v", h.pinPath, err)
+		return nil
+	}
+	newID := programID(prog)
+	if newID == h.progID {
+		prog.Close()
+		return nil
+	}
+
+	funcName, err := followedFunc(h.prog, prog, h.funcName)
+	if err != nil {
+		prog.Close()
+		return fmt.Errorf("refusing to follow %s to program %d: %w", h.pinPath, newID, err)
+	}
+
+	replacements := h.counterMaps()
+	if reset {
+		replacements = nil
+	}
+	obj, l, err := attachFexit(spec, prog, funcName, h.cookie, replacements)
+	if err != nil {
+		prog.Close()
+		warnLog.Printf("Failed to follow %s from program %d to %d: %v", h.pinPath, h.progID, newID, err)
+		return nil
+	}
+
+	h.logEvent("reload", "%s now holds program %d instead of %d, re-attached %s", h.pinPath, newID, h.progID, funcName)
+	h.link.Close()
+	h.obj.Close()
+	if h.ownsProg {
+		h.prog.Close()
+	}
+	h.prog, h.progID, h.ownsProg = prog, newID, true
+	h.funcName = funcName
+	h.obj, h.link = obj, l
+	h.backoff, h.nextAttach = 0, time.Time{}
+	if reset {
+		h.prevValues, h.prevTime = make(map[uint32]uint64), time.Time{}
+		if h.baseline != nil {
+			h.baseline = make(map[uint32]uint64)
+		}
+		h.tripCount = 0
+	}
+	return nil
+}
+
+// followedFunc returns the function of newProg that a hook on funcName of
+// oldProg moves to: the new entry function if funcName was the old one,
+// and funcName itself otherwise. It runs the same checks as the initial
+// attach, so it fails with ErrNoBTF for programs without BTF.
+func followedFunc(oldProg, newProg *ebpf.Program, funcName string) (string, error) {
+	entry, err := getFuncName(newProg)
+	if err != nil {
+		return "", err
+	}
+	if oldEntry, err := getFuncName(oldProg); err == nil && oldEntry == funcName {
+		return entry, nil
+	}
+	names, err := getHookableFuncNames(newProg)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if name == funcName {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("program has no hookable function %s", funcName)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// probeResult is one row of --probe, and its JSON form.
+type probeResult struct {
+	Feature   string `json:"feature"`
+	Available bool   `json:"available"`
+	Detail    string `json:"detail,omitempty"`
+	UsedBy    string `json:"used_by"`
+}
+
+// probeFeatures checks which kernel features tcmonitor can use on this
+// host. Unlike --self-test, it does not load tcmonitor's own objects.
+func probeFeatures() []probeResult {
+	probes := []struct {
+		feature, usedBy string
+		probe           func() error
+	}{
+		{"fexit on TC programs", "tracing (required)", checkFexitSupport},
+		{"Kernel BTF", "tracing (required)", func() error {
+			_, err := btf.LoadKernelSpec()
+			return err
+		}},
+		{"Per-CPU maps", "action counters, --by-cpu", func() error { return features.HaveMapType(ebpf.PerCPUArray) }},
+		{"Ring buffer", "none yet", func() error { return features.HaveMapType(ebpf.RingBuf) }},
+		{"TCX", "--auto interface labels", probeTCX},
+		{"BPF statistics", "--self-stats, --enable-bpf-stats", func() error {
+			stats, err := enableBPFStats()
+			if err != nil {
+				return err
+			}
+			return stats.Close()
+		}},
+	}
+
+	results := make([]probeResult, 0, len(probes))
+	for _, p := range probes {
+		r := probeResult{Feature: p.feature, Available: true, UsedBy: p.usedBy}
+		if err := p.probe(); err != nil {
+			r.Available = false
+			r.Detail = err.Error()
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// probeTCX attaches a trivial program through TCX to an interface that
+// cannot exist. Kernels with TCX reject the interface, older ones the
+// link type.
+func probeTCX() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SchedCLS,
+		License: "Dual BSD/GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load test program: %w", err)
+	}
+	defer prog.Close()
+
+	l, err := link.AttachTCX(link.TCXOptions{
+		Interface: math.MaxInt32,
+		Program:   prog,
+		Attach:    ebpf.AttachTCXIngress,
+	})
+	switch {
+	case err == nil:
+		l.Close()
+		return nil
+	case errors.Is(err, unix.ENODEV):
+		return nil
+	default:
+		return err
+	}
+}
+
+// writeProbeResults writes results as a table, or as a JSON array if
+// format is "json".
+func writeProbeResults(w io.Writer, results []probeResult, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	featureW := len("FEATURE")
+	for _, r := range results {
+		featureW = max(featureW, len(r.Feature))
+	}
+	fmt.Fprintf(w, "%-*s  %-9s  %s\n", featureW, "FEATURE", "AVAILABLE", "USED BY")
+	for _, r := range results {
+		available := "yes"
+		if !r.Available {
+			available = "no"
+		}
+		fmt.Fprintf(w, "%-*s  %-9s  %s\n", featureW, r.Feature, available, r.UsedBy)
+		if r.Detail != "" {
+			fmt.Fprintf(w, "%-*s  %s\n", featureW+11, "", r.Detail)
+		}
+	}
+	return nil
+}
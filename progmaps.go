@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+// printProgramMaps lists the maps used by prog with their type, name and
+// size. Maps that cannot be opened are listed with the error.
+func printProgramMaps(w io.Writer, prog *ebpf.Program) {
+	info, err := prog.Info()
+	if err != nil {
+		fmt.Fprintf(w, "Failed to get info of program %d: %v\n", programID(prog), err)
+		return
+	}
+	ids, ok := info.MapIDs()
+	if !ok {
+		fmt.Fprintf(w, "Program %d: map IDs are not available on this kernel\n", programID(prog))
+		return
+	}
+
+	fmt.Fprintf(w, "Program %d uses %d maps:\n", programID(prog), len(ids))
+	for _, id := range ids {
+		m, err := ebpf.NewMapFromID(id)
+		if err != nil {
+			fmt.Fprintf(w, "  map %d: %v\n", id, err)
+			continue
+		}
+		mapInfo, err := m.Info()
+		m.Close()
+		if err != nil {
+			fmt.Fprintf(w, "  map %d: %v\n", id, err)
+			continue
+		}
+		fmt.Fprintf(w, "  map %d %s %q max_entries=%d key_size=%d value_size=%d\n",
+			id, mapInfo.Type, mapInfo.Name, mapInfo.MaxEntries, mapInfo.KeySize, mapInfo.ValueSize)
+	}
+}
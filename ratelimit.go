@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// rateLimitedLogger logs each distinct message at most once per window and
+// reports how many repeats were suppressed in between.
+type rateLimitedLogger struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]*suppressedMessage
+}
+
+type suppressedMessage struct {
+	logged     time.Time
+	suppressed int
+}
+
+// warnLog is used for warnings that can repeat every refresh.
+var warnLog = newRateLimitedLogger(time.Minute)
+
+func newRateLimitedLogger(window time.Duration) *rateLimitedLogger {
+	return &rateLimitedLogger{
+		window: window,
+		seen:   make(map[string]*suppressedMessage),
+	}
+}
+
+func (l *rateLimitedLogger) Printf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m, ok := l.seen[msg]
+	if !ok {
+		m = &suppressedMessage{}
+		l.seen[msg] = m
+	} else if now.Sub(m.logged) < l.window {
+		m.suppressed++
+		l.prune(now, msg)
+		return
+	}
+
+	if m.suppressed > 0 {
+		log.Printf("%s (suppressed %d repeats in the last %v)", msg, m.suppressed, now.Sub(m.logged).Round(time.Second))
+	} else {
+		log.Print(msg)
+	}
+	m.logged = now
+	m.suppressed = 0
+	l.prune(now, msg)
+}
+
+// prune forgets the messages, other than keep, last logged a window or
+// more ago, so that messages that stop repeating do not pile up. Repeats
+// suppressed since then are reported before the message is forgotten.
+func (l *rateLimitedLogger) prune(now time.Time, keep string) {
+	for msg, m := range l.seen {
+		if msg == keep || now.Sub(m.logged) < l.window {
+			continue
+		}
+		if m.suppressed > 0 {
+			log.Printf("%s (suppressed %d repeats in the %v after it was logged)", msg, m.suppressed, l.window)
+		}
+		delete(l.seen, msg)
+	}
+}
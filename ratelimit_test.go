@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedLogger(t *testing.T) {
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	}()
+
+	l := newRateLimitedLogger(50 * time.Millisecond)
+	l.Printf("map read failed")
+	l.Printf("map read failed")
+	l.Printf("map read failed")
+	if got := strings.Count(buf.String(), "map read failed"); got != 1 {
+		t.Errorf("logged %d times within the window, want 1", got)
+	}
+
+	// Once the window is over, a new message prunes the old one and
+	// reports its suppressed repeats.
+	time.Sleep(60 * time.Millisecond)
+	l.Printf("attach failed")
+	if len(l.seen) != 1 {
+		t.Errorf("%d messages kept, want 1", len(l.seen))
+	}
+	if !strings.Contains(buf.String(), "map read failed (suppressed 2 repeats") {
+		t.Errorf("no summary of the suppressed repeats in %q", buf.String())
+	}
+}
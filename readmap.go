@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cilium/ebpf"
+)
+
+// checkActionMapLayout returns an error unless m, pinned at path, has the
+// layout of tc_action_count_map.
+func checkActionMapLayout(spec *ebpf.CollectionSpec, m *ebpf.Map, path string) error {
+	want := spec.Maps["tc_action_count_map"]
+	if m.KeySize() != 4 || m.ValueSize() != 8 {
+		return fmt.Errorf("%s has %d byte keys and %d byte values, expected a u32 action key and a u64 count", path, m.KeySize(), m.ValueSize())
+	}
+	if m.Type() != want.Type || m.MaxEntries() != want.MaxEntries {
+		return fmt.Errorf("%s is a %s with %d entries, expected a %s with %d entries", path, m.Type(), m.MaxEntries(), want.Type, want.MaxEntries)
+	}
+	return nil
+}
+
+// bpfObjNameLen is the longest map name the kernel keeps, BPF_OBJ_NAME_LEN
+// without the terminating NUL.
+const bpfObjNameLen = 15
+
+// replacePin pins m at path for --pin-map. A pin left behind by an earlier
+// run that did not exit cleanly is replaced, but only if it is a tcmonitor
+// action map, so that other tools' pins are never removed.
+func replacePin(spec *ebpf.CollectionSpec, m *ebpf.Map, path string) error {
+	old, err := ebpf.LoadPinnedMap(path, &ebpf.LoadPinOptions{ReadOnly: true})
+	if err == nil {
+		defer old.Close()
+		info, err := old.Info()
+		if err != nil {
+			return fmt.Errorf("failed to get info of existing pin %s: %w", path, err)
+		}
+		// The kernel keeps only the first BPF_OBJ_NAME_LEN-1 bytes of a
+		// map name, so the pin of tc_action_count_map reads back as
+		// "tc_action_count".
+		if want := "tc_action_count_map"; info.Name != want[:min(len(want), bpfObjNameLen)] {
+			return fmt.Errorf("%s is already pinned by something else (map %q)", path, info.Name)
+		}
+		if err := checkActionMapLayout(spec, old, path); err != nil {
+			return fmt.Errorf("refusing to replace existing pin: %w", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale pin: %w", err)
+		}
+		log.Printf("Replaced stale action map pin %s", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check existing pin %s: %w", path, err)
+	}
+	return m.Pin(path)
+}
+
+// newReadOnlyHook opens the action map pinned at path read-only, for
+// displaying counters collected by another tcmonitor process. The map must
+// have the layout of tc_action_count_map. The breakdown maps are created
+// empty, since only the action map is shared, which is why the breakdown
+// flags are rejected with --read-map.
+func newReadOnlyHook(spec *ebpf.CollectionSpec, path string) (*fexitHook, error) {
+	m, err := ebpf.LoadPinnedMap(path, &ebpf.LoadPinOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pinned map: %w", err)
+	}
+	defer m.Close()
+	if err := checkActionMapLayout(spec, m, path); err != nil {
+		return nil, err
+	}
+
+	obj := new(tcmonitorObjects)
+	opts := &ebpf.CollectionOptions{MapReplacements: map[string]*ebpf.Map{"tc_action_count_map": m}}
+	if err := spec.LoadAndAssign(&obj.tcmonitorMaps, opts); err != nil {
+		return nil, fmt.Errorf("failed to create maps: %w", err)
+	}
+	return &fexitHook{
+		funcName:   "READ-ONLY",
+		pinnedMap:  path,
+		obj:        obj,
+		prevValues: make(map[uint32]uint64),
+		firing:     make(map[uint32]bool),
+	}, nil
+}
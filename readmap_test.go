@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestReplacePin(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Skipf("Cannot remove memlock rlimit: %v", err)
+	}
+	dir, err := os.MkdirTemp("/sys/fs/bpf", "tcmonitor-test")
+	if err != nil {
+		t.Skipf("No bpffs to pin in: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	actionSpec := &ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 4}
+	spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{"tc_action_count_map": actionSpec}}
+	newMap := func(name string) *ebpf.Map {
+		s := actionSpec.Copy()
+		s.Name = name
+		m, err := ebpf.NewMap(s)
+		if err != nil {
+			t.Skipf("Cannot create map: %v", err)
+		}
+		t.Cleanup(func() { m.Close() })
+		return m
+	}
+	pinnedID := func(path string) ebpf.MapID {
+		m, err := ebpf.LoadPinnedMap(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		info, err := m.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := info.ID()
+		return id
+	}
+	mapID := func(m *ebpf.Map) ebpf.MapID {
+		info, err := m.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := info.ID()
+		return id
+	}
+
+	// A stale pin of an action map from an earlier run is replaced.
+	path := filepath.Join(dir, "actions")
+	if err := newMap("tc_action_count_map").Pin(path); err != nil {
+		t.Fatal(err)
+	}
+	m := newMap("tc_action_count_map")
+	if err := replacePin(spec, m, path); err != nil {
+		t.Fatalf("replacing a stale pin: %v", err)
+	}
+	if got, want := pinnedID(path), mapID(m); got != want {
+		t.Errorf("pin holds map %d, want %d", got, want)
+	}
+
+	// Maps of other tools are left alone, even if their name is a prefix
+	// of the action map's.
+	for _, name := range []string{"tc", "tc_a", "other"} {
+		path := filepath.Join(dir, name)
+		foreign := newMap(name)
+		if err := foreign.Pin(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := replacePin(spec, newMap("tc_action_count_map"), path); err == nil {
+			t.Errorf("map %q: replaced", name)
+		}
+		if got, want := pinnedID(path), mapID(foreign); got != want {
+			t.Errorf("map %q: pin holds map %d, want %d", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// recorder is the --record flight recorder: it samples the raw action
+// counters of every hook at a fine interval into a fixed-size ring, which
+// is written out on SIGUSR1 and on exit.
+type recorder struct {
+	path    string
+	samples []recordSample
+	next    int
+	full    bool
+}
+
+// recordSample holds the counters of one hook at one instant.
+type recordSample struct {
+	at       time.Time
+	progID   int
+	funcName string
+	counts   []actionCount
+}
+
+type actionCount struct {
+	code  uint32
+	count uint64
+}
+
+func newRecorder(path string, size int) *recorder {
+	return &recorder{path: path, samples: make([]recordSample, size)}
+}
+
+// sample records the current counters of every displayed hook.
+func (r *recorder) sample(hooks []*fexitHook) {
+	now := time.Now()
+	for _, h := range hooks {
+		if h.cloneOf != nil {
+			continue
+		}
+		counts, keys, err := actionCounts(h.obj.TcActionCountMap)
+		if err != nil {
+			warnLog.Printf("Error recording %s: %v", h.label(), err)
+			continue
+		}
+		s := recordSample{at: now, progID: h.progID, funcName: h.funcName}
+		s.counts = make([]actionCount, 0, len(keys))
+		for _, key := range keys {
+			s.counts = append(s.counts, actionCount{key, counts[key]})
+		}
+		r.samples[r.next] = s
+		r.next = (r.next + 1) % len(r.samples)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+}
+
+// dump atomically replaces the record file with the retained samples as
+// CSV, oldest first, one row per action.
+func (r *recorder) dump() error {
+	samples := r.samples[:r.next]
+	if r.full {
+		samples = append(append([]recordSample{}, r.samples[r.next:]...), r.samples[:r.next]...)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("time,program,function,action,count\n")
+	for _, s := range samples {
+		for _, c := range s.counts {
+			fmt.Fprintf(&buf, "%s,%d,%s,%s,%d\n", s.at.Format(time.RFC3339Nano), s.progID, s.funcName, actionName(c.code), c.count)
+		}
+	}
+	return writeFileAtomic(r.path, buf.Bytes())
+}
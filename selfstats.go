@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// writeSelfStats writes how much memory tcmonitor's own maps use and how
+// many fexit links it holds. Clones share their leader's maps, so those
+// are counted once. With BPF statistics enabled, it also writes how much
+// time the fexit programs add to every packet.
+func writeSelfStats(w io.Writer, hooks []*fexitHook) {
+	var maps, links int
+	var bytes, runs uint64
+	var runtime time.Duration
+	for _, h := range hooks {
+		if h.link != nil {
+			links++
+		}
+		if h.obj.FexitTc != nil {
+			if info, err := h.obj.FexitTc.Info(); err == nil {
+				n, _ := info.RunCount()
+				t, _ := info.Runtime()
+				runs += n
+				runtime += t
+			}
+		}
+		if h.cloneOf != nil {
+			continue
+		}
+		for _, m := range h.counterMaps() {
+			maps++
+			bytes += mapMemory(m)
+		}
+	}
+	fmt.Fprintf(w, "\ntcmonitor: %d maps using %.1f KiB, %d links\n", maps, float64(bytes)/1024, links)
+	if runs > 0 {
+		fmt.Fprintf(w, "tcmonitor: fexit programs ran %d times, %v per run on average\n", runs, runtime/time.Duration(runs))
+	}
+}
+
+// enableBPFStats turns on the kernel's run time and run count accounting
+// of BPF programs until the returned Closer is closed. The kernel counts
+// every enabler, including the kernel.bpf_stats_enabled sysctl, so closing
+// it restores whatever was set before.
+func enableBPFStats() (io.Closer, error) {
+	return ebpf.EnableStats(unix.BPF_STATS_RUN_TIME)
+}
+
+// mapMemory returns the memory the kernel charges for m. Kernels that do not
+// report it get an estimate from the key and value sizes.
+func mapMemory(m *ebpf.Map) uint64 {
+	if info, err := m.Info(); err == nil {
+		if memlock, ok := info.Memlock(); ok {
+			return memlock
+		}
+	}
+
+	valueSize := uint64(m.ValueSize())
+	if isPerCPU(m) {
+		if cpus, err := ebpf.PossibleCPU(); err == nil {
+			valueSize *= uint64(cpus)
+		}
+	}
+	return (uint64(m.KeySize()) + valueSize) * uint64(m.MaxEntries())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+)
+
+// runSelfTest checks that tcmonitor's own BPF objects can be used on this
+// kernel without attaching to anything, printing PASS/FAIL per component.
+// It reports whether every check passed.
+func runSelfTest(spec *ebpf.CollectionSpec) bool {
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"BPF maps", func() error { return createMaps(spec) }},
+		{"fexit (tracing programs)", func() error { return features.HaveProgramType(ebpf.Tracing) }},
+		{"Kernel BTF", func() error {
+			_, err := btf.LoadKernelSpec()
+			return err
+		}},
+	}
+
+	ok := true
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			fmt.Printf("FAIL %s: %v\n", c.name, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("PASS %s\n", c.name)
+	}
+	return ok
+}
+
+// checkFexitSupport returns an error wrapping ErrNoFexit if the kernel
+// lacks what tcmonitor needs to attach fexit to a TC program: tracing
+// programs and kernel BTF.
+func checkFexitSupport() error {
+	if err := features.HaveProgramType(ebpf.Tracing); errors.Is(err, ebpf.ErrNotSupported) {
+		return fmt.Errorf("%w: tracing programs are not supported, Linux 5.5 or later is required", ErrNoFexit)
+	} else if err != nil {
+		return fmt.Errorf("failed to probe for tracing programs: %w", err)
+	}
+	if _, err := btf.LoadKernelSpec(); err != nil {
+		return fmt.Errorf("%w: kernel BTF is not available (is CONFIG_DEBUG_INFO_BTF enabled?): %v", ErrNoFexit, err)
+	}
+	return nil
+}
+
+// createMaps creates and immediately closes every map in spec.
+func createMaps(spec *ebpf.CollectionSpec) error {
+	names := make([]string, 0, len(spec.Maps))
+	for name := range spec.Maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m, err := ebpf.NewMap(spec.Maps[name])
+		if err != nil {
+			return fmt.Errorf("map %s: %w", name, err)
+		}
+		m.Close()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/cilium/ebpf"
+)
+
+// simulatedWeights sets how likely each action is in --simulate mode, so
+// the synthetic numbers look roughly like real traffic.
+var simulatedWeights = map[uint32]int{
+	0: 1000, // TC_ACT_OK
+	2: 50,   // TC_ACT_SHOT
+	3: 10,   // TC_ACT_PIPE
+	7: 100,  // TC_ACT_REDIRECT
+}
+
+// newSimulatedHook creates tcmonitor's maps without loading or attaching
+// fexit_tc, for feeding them synthetic counts.
+func newSimulatedHook(spec *ebpf.CollectionSpec) (*fexitHook, error) {
+	obj := new(tcmonitorObjects)
+	if err := spec.LoadAndAssign(&obj.tcmonitorMaps, nil); err != nil {
+		return nil, fmt.Errorf("failed to create maps: %w", err)
+	}
+	return &fexitHook{
+		funcName:   "SIMULATED",
+		obj:        obj,
+		prevValues: make(map[uint32]uint64),
+		firing:     make(map[uint32]bool),
+		simulated:  true,
+	}, nil
+}
+
+// simulateTraffic adds a random number of fake packets to every weighted
+// action in the hook's action map.
+func (h *fexitHook) simulateTraffic() error {
+	m := h.obj.TcActionCountMap
+	for key, weight := range simulatedWeights {
+		if key >= m.MaxEntries() {
+			continue
+		}
+		if err := addCount(m, &key, uint64(rand.IntN(weight+1))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
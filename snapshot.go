@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// Snapshot holds the counters of one fexit hook at a single refresh. Every
+// output (the table, --template, --history and the alerts) is rendered from
+// it, so they always agree. Its exported fields are what --template is
+// evaluated against.
+type Snapshot struct {
+	ProgramID int
+	Function  string
+	Where     string // Interfaces and directions the program runs on, from --auto
+	Timestamp time.Time
+	Interval  time.Duration
+	Actions   map[string]ActionStats
+	Total     uint64 // Sum of the actions not excluded from the total
+	WarmingUp bool   // Rates are not meaningful yet and are left at 0
+	Baseline  bool   // Counts are shown relative to --baseline
+
+	order []string
+}
+
+// hookSnapshot is a Snapshot labelled with the hook it was taken from, as
+// written to JSON outputs.
+type hookSnapshot struct {
+	Label string
+	*Snapshot
+}
+
+// ActionStats holds the counter of a single action within a Snapshot.
+// Percent is its share of the Snapshot's Total, or 0 for excluded actions.
+// With --baseline, Delta is the change of Count since startup, negative if
+// the map was reset since, and Total and Percent are taken over the deltas.
+type ActionStats struct {
+	Code    uint32
+	Count   uint64
+	Delta   int64
+	Rate    float64
+	Percent float64
+}
+
+// precision is the number of decimals shown for rates and percentages,
+// set with --precision. JSON outputs are not rounded.
+var precision = 2
+
+// warmupUntil ends the --warmup period. The first snapshot of a hook is
+// always warming up, since there is no earlier sample to take a rate from.
+var warmupUntil time.Time
+
+// excludedFromTotal holds the actions set with --exclude-from-total. They
+// are still listed, but left out of Snapshot.Total and the percentages.
+var excludedFromTotal = map[uint32]bool{}
+
+// totalRate returns the combined per-second rate of the actions included
+// in the total.
+func (s *Snapshot) totalRate() float64 {
+	var rate float64
+	for _, a := range s.Actions {
+		if !excludedFromTotal[a.Code] {
+			rate += a.Rate
+		}
+	}
+	return rate
+}
+
+// byCode returns the stats of the action with the given value.
+func (s *Snapshot) byCode(code uint32) (ActionStats, bool) {
+	for _, a := range s.Actions {
+		if a.Code == code {
+			return a, true
+		}
+	}
+	return ActionStats{}, false
+}
+
+// writeTable writes one line per action with its count and rate, followed
+// by the action's sparkline if sp is not nil.
+func (s *Snapshot) writeTable(w io.Writer, sp *sparklines) {
+	rows := make([]tableRow, 0, len(s.order))
+	for _, action := range s.order {
+		a := s.Actions[action]
+		r := tableRow{
+			name:     action,
+			count:    a.Count,
+			rate:     a.Rate,
+			percent:  a.Percent,
+			excluded: excludedFromTotal[a.Code],
+		}
+		if s.Baseline {
+			r.delta = &a.Delta
+		}
+		if sp != nil {
+			r.suffix = sp.render(a.Code)
+		}
+		rows = append(rows, r)
+	}
+	writeRows(w, rows, s.WarmingUp)
+}
+
+// writeRates writes the per-second rate of every action on a single line.
+func (s *Snapshot) writeRates(w io.Writer) {
+	fmt.Fprint(w, s.Timestamp.Format("15:04:05"))
+	if s.WarmingUp {
+		fmt.Fprintln(w, " warming up")
+		return
+	}
+	for _, action := range s.order {
+		fmt.Fprintf(w, " %s=%.*f/s", action, precision, s.Actions[action].Rate)
+	}
+	fmt.Fprintln(w)
+}
+
+// snapshot reads the action counters of h and computes their rates since
+// the previous call. It returns nil if no time has passed since then.
+func (h *fexitHook) snapshot() *Snapshot {
+	s := lookupStats(h.obj.TcActionCountMap, h.prevValues, &h.prevTime)
+	if s == nil {
+		return nil
+	}
+	s.ProgramID = h.progID
+	s.Function = h.funcName
+	s.Where = h.where
+	if h.baseline != nil {
+		s.applyBaseline(h.baseline)
+	}
+	return s
+}
+
+// applyBaseline makes the counts of s relative to baseline. Negative deltas
+// count as 0 towards Total and Percent, so the shares still add up to 100%.
+func (s *Snapshot) applyBaseline(baseline map[uint32]uint64) {
+	s.Baseline = true
+	s.Total = 0
+	for action, a := range s.Actions {
+		a.Delta = int64(a.Count - baseline[a.Code])
+		s.Actions[action] = a
+		if !excludedFromTotal[a.Code] && a.Delta > 0 {
+			s.Total += uint64(a.Delta)
+		}
+	}
+	for action, a := range s.Actions {
+		a.Percent = 0
+		if s.Total > 0 && !excludedFromTotal[a.Code] && a.Delta > 0 {
+			a.Percent = 100 * float64(a.Delta) / float64(s.Total)
+		}
+		s.Actions[action] = a
+	}
+}
+
+// lookupStats reads every slot of the action map and computes rates against
+// prevValues. A zero prevTime means there is no previous sample, so the
+// snapshot is warming up. It returns nil if no time has passed since
+// prevTime.
+func lookupStats(ebpfMap *ebpf.Map, prevValues map[uint32]uint64, prevTime *time.Time) *Snapshot {
+	now := time.Now()
+	first := prevTime.IsZero()
+	var interval time.Duration
+	if !first {
+		interval = now.Sub(*prevTime)
+		if interval == 0 {
+			return nil // Avoid division by zero
+		}
+	}
+	deltaTime := interval.Seconds()
+	s := &Snapshot{
+		Timestamp: now,
+		Interval:  interval,
+		Actions:   make(map[string]ActionStats),
+		WarmingUp: first || now.Before(warmupUntil),
+	}
+	counts, keys, err := actionCounts(ebpfMap)
+	if err != nil {
+		warnLog.Printf("Error reading action counters: %v", err)
+		return nil
+	}
+	for _, key := range keys {
+		action := actionName(key)
+		value := counts[key]
+		prev := prevValues[key]
+		prevValues[key] = value
+		a := ActionStats{Code: key, Count: value}
+		// A counter that went down was reset or re-created, so there is no
+		// rate to take over this interval.
+		if !s.WarmingUp && value >= prev {
+			a.Rate = float64(value-prev) / deltaTime
+		}
+		s.Actions[action] = a
+		if !excludedFromTotal[key] {
+			s.Total += value
+		}
+		s.order = append(s.order, action)
+	}
+	if s.Total > 0 {
+		for action, a := range s.Actions {
+			if !excludedFromTotal[a.Code] {
+				a.Percent = 100 * float64(a.Count) / float64(s.Total)
+				s.Actions[action] = a
+			}
+		}
+	}
+	*prevTime = now
+	return s
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// testSnapshot returns a fixed snapshot of one hook with two actions.
+func testSnapshot() *Snapshot {
+	return &Snapshot{
+		ProgramID: 42,
+		Function:  "cls_main",
+		Timestamp: time.Unix(1700000000, 0),
+		Interval:  time.Second,
+		Actions: map[string]ActionStats{
+			"TC_ACT_OK":   {Code: 0, Count: 100, Rate: 10, Percent: 80},
+			"TC_ACT_SHOT": {Code: 2, Count: 25, Rate: 2.5, Percent: 20},
+		},
+		Total: 125,
+		order: []string{"TC_ACT_OK", "TC_ACT_SHOT"},
+	}
+}
+
+// TestSinksAgree renders one snapshot through every output and checks that
+// they all report the same counts and rates.
+func TestSinksAgree(t *testing.T) {
+	s := testSnapshot()
+	wantCounts := map[string]uint64{"TC_ACT_OK": 100, "TC_ACT_SHOT": 25}
+	wantRates := map[string]float64{"TC_ACT_OK": 10, "TC_ACT_SHOT": 2.5}
+	labeled := []hookSnapshot{{Label: "cls_main (program 42)", Snapshot: s}}
+
+	check := func(t *testing.T, counts map[string]uint64, rates map[string]float64) {
+		t.Helper()
+		if !maps.Equal(counts, wantCounts) {
+			t.Errorf("counts = %v, want %v", counts, wantCounts)
+		}
+		if rates != nil && !maps.Equal(rates, wantRates) {
+			t.Errorf("rates = %v, want %v", rates, wantRates)
+		}
+	}
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		s.writeTable(&buf, nil)
+		counts := make(map[string]uint64)
+		rates := make(map[string]float64)
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var name, rate string
+			var count uint64
+			if _, err := fmt.Sscanf(line, "%s %d (Rate: %s", &name, &count, &rate); err != nil {
+				t.Fatalf("unexpected line %q: %v", line, err)
+			}
+			name = strings.TrimSuffix(name, ":")
+			counts[name] = count
+			rates[name], _ = strconv.ParseFloat(strings.TrimSuffix(rate, "/s,"), 64)
+		}
+		check(t, counts, rates)
+	})
+
+	t.Run("template", func(t *testing.T) {
+		tmpl, err := parseTemplate(`{{range $name, $a := .Actions}}{{$name}} {{$a.Count}} {{$a.Rate}}{{"\n"}}{{end}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, s); err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[string]uint64)
+		rates := make(map[string]float64)
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var name string
+			var count uint64
+			var rate float64
+			if _, err := fmt.Sscan(line, &name, &count, &rate); err != nil {
+				t.Fatalf("unexpected line %q: %v", line, err)
+			}
+			counts[name], rates[name] = count, rate
+		}
+		check(t, counts, rates)
+	})
+
+	t.Run("state file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		if err := writeStateFile(path, labeled); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, rates := decodeSnapshots(t, data)
+		check(t, counts, rates)
+	})
+
+	t.Run("dashboard", func(t *testing.T) {
+		var d dashboard
+		d.update(labeled)
+		rec := httptest.NewRecorder()
+		d.serveData(rec, httptest.NewRequest("GET", "/data.json", nil))
+		counts, rates := decodeSnapshots(t, rec.Body.Bytes())
+		check(t, counts, rates)
+	})
+
+	t.Run("graphite", func(t *testing.T) {
+		counts := make(map[string]uint64)
+		lines := strings.TrimSpace(string(graphiteLines("tcmonitor", []*Snapshot{s})))
+		for _, line := range strings.Split(lines, "\n") {
+			var metric string
+			var count uint64
+			var ts int64
+			if _, err := fmt.Sscan(line, &metric, &count, &ts); err != nil {
+				t.Fatalf("unexpected line %q: %v", line, err)
+			}
+			counts[strings.TrimPrefix(metric, "tcmonitor.prog42.")] = count
+			if ts != s.Timestamp.Unix() {
+				t.Errorf("timestamp of %q = %d, want %d", line, ts, s.Timestamp.Unix())
+			}
+		}
+		check(t, counts, nil)
+	})
+
+	t.Run("statsd", func(t *testing.T) {
+		// Prime the previous counts with zero, so the counters carry the
+		// full counts.
+		sink := &statsdSink{prefix: "tcmonitor", prev: map[string]uint64{
+			"tcmonitor.prog42.TC_ACT_OK":   0,
+			"tcmonitor.prog42.TC_ACT_SHOT": 0,
+		}}
+		counts := make(map[string]uint64)
+		rates := make(map[string]float64)
+		for _, line := range sink.lines([]*Snapshot{s}) {
+			name, value, _ := strings.Cut(line, ":")
+			name = strings.TrimPrefix(name, "tcmonitor.prog42.")
+			switch {
+			case strings.HasSuffix(value, "|c"):
+				counts[name], _ = strconv.ParseUint(strings.TrimSuffix(value, "|c"), 10, 64)
+			case strings.HasSuffix(value, "|g"):
+				rates[strings.TrimSuffix(name, ".rate")], _ = strconv.ParseFloat(strings.TrimSuffix(value, "|g"), 64)
+			default:
+				t.Fatalf("unexpected line %q", line)
+			}
+		}
+		check(t, counts, rates)
+	})
+}
+
+// decodeSnapshots returns the counts and rates of the single hook in the
+// JSON array data.
+func decodeSnapshots(t *testing.T, data []byte) (map[string]uint64, map[string]float64) {
+	t.Helper()
+	var hooks []struct {
+		Label   string
+		Actions map[string]struct {
+			Count uint64
+			Rate  float64
+		}
+	}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 {
+		t.Fatalf("got %d hooks, want 1", len(hooks))
+	}
+	counts := make(map[string]uint64)
+	rates := make(map[string]float64)
+	for name, a := range hooks[0].Actions {
+		counts[name], rates[name] = a.Count, a.Rate
+	}
+	return counts, rates
+}
+
+func TestApplyBaseline(t *testing.T) {
+	s := testSnapshot()
+	s.applyBaseline(map[uint32]uint64{0: 40, 2: 30})
+
+	if !s.Baseline {
+		t.Error("Baseline not set")
+	}
+	if got := s.Actions["TC_ACT_OK"].Delta; got != 60 {
+		t.Errorf("OK delta = %d, want 60", got)
+	}
+	if got := s.Actions["TC_ACT_SHOT"].Delta; got != -5 {
+		t.Errorf("SHOT delta = %d, want -5", got)
+	}
+	// The negative delta counts as 0 towards both Total and the shares.
+	if s.Total != 60 {
+		t.Errorf("Total = %d, want 60", s.Total)
+	}
+	if got := s.Actions["TC_ACT_OK"].Percent; got != 100 {
+		t.Errorf("OK percent = %v, want 100", got)
+	}
+	if got := s.Actions["TC_ACT_SHOT"].Percent; got != 0 {
+		t.Errorf("SHOT percent = %v, want 0", got)
+	}
+}
+
+func TestLookupStats(t *testing.T) {
+	m := newTestMap(t, ebpf.Hash, 4)
+	prevValues := make(map[uint32]uint64)
+	var prevTime time.Time
+
+	// A map that stays empty still ends the warm-up after the first sample.
+	if s := lookupStats(m, prevValues, &prevTime); s == nil || !s.WarmingUp {
+		t.Fatalf("first snapshot = %+v, want warming up", s)
+	}
+	time.Sleep(time.Millisecond)
+	if s := lookupStats(m, prevValues, &prevTime); s == nil || s.WarmingUp {
+		t.Fatalf("second snapshot = %+v, want not warming up", s)
+	}
+
+	// A counter that goes down has no rate rather than a wrapped one.
+	prevValues[0] = 100
+	if err := m.Put(uint32(0), uint64(10)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	s := lookupStats(m, prevValues, &prevTime)
+	if s == nil {
+		t.Fatal("no snapshot")
+	}
+	if got := s.Actions["TC_ACT_OK"]; got.Count != 10 || got.Rate != 0 {
+		t.Errorf("OK = %+v, want count 10 and rate 0", got)
+	}
+}
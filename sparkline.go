@@ -0,0 +1,51 @@
+package main
+
+import "strings"
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparklines keeps the last size rates of every action of a hook.
+type sparklines struct {
+	size  int
+	rates map[uint32][]float64
+}
+
+func newSparklines(size int) *sparklines {
+	return &sparklines{size: size, rates: make(map[uint32][]float64)}
+}
+
+// record appends the rates in s, dropping the oldest beyond size. Rates of
+// warming up snapshots are skipped.
+func (sp *sparklines) record(s *Snapshot) {
+	if s.WarmingUp {
+		return
+	}
+	for _, a := range s.Actions {
+		rates := append(sp.rates[a.Code], a.Rate)
+		if len(rates) > sp.size {
+			rates = rates[len(rates)-sp.size:]
+		}
+		sp.rates[a.Code] = rates
+	}
+}
+
+// render draws the recorded rates of action, scaled to their maximum.
+func (sp *sparklines) render(action uint32) string {
+	rates := sp.rates[action]
+	var max float64
+	for _, r := range rates {
+		if r > max {
+			max = r
+		}
+	}
+
+	var b strings.Builder
+	for _, r := range rates {
+		i := 0
+		if max > 0 {
+			i = int(r / max * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
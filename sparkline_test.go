@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestSparklines(t *testing.T) {
+	sp := newSparklines(4)
+	for _, rate := range []float64{0, 7, 1, 3.5, 7} {
+		sp.record(&Snapshot{Actions: map[string]ActionStats{
+			"TC_ACT_OK": {Code: 0, Rate: rate},
+		}})
+	}
+	// Warming up snapshots are left out.
+	sp.record(&Snapshot{WarmingUp: true, Actions: map[string]ActionStats{
+		"TC_ACT_OK": {Code: 0, Rate: 100},
+	}})
+
+	// The oldest rate is dropped, the rest are scaled to the maximum.
+	if got, want := sp.render(0), "█▂▄█"; got != want {
+		t.Errorf("render = %q, want %q", got, want)
+	}
+	if got := sp.render(2); got != "" {
+		t.Errorf("render of an unseen action = %q, want none", got)
+	}
+
+	zero := newSparklines(2)
+	zero.record(&Snapshot{Actions: map[string]ActionStats{"TC_ACT_OK": {}}})
+	if got, want := zero.render(0), "▁"; got != want {
+		t.Errorf("render of zero rates = %q, want %q", got, want)
+	}
+}
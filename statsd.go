@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// statsdMaxPacket keeps datagrams below the usual Ethernet MTU, so that
+// collectors do not receive fragmented or truncated metrics.
+const statsdMaxPacket = 1432
+
+// statsdSink sends snapshots to a StatsD collector over UDP. Datagrams are
+// fire-and-forget, so a collector that is down only costs the lost metrics.
+type statsdSink struct {
+	prefix string
+	conn   net.Conn
+	prev   map[string]uint64 // Last count sent per metric name
+}
+
+func newStatsdSink(addr, prefix string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{
+		prefix: strings.TrimSuffix(prefix, "."),
+		conn:   conn,
+		prev:   make(map[string]uint64),
+	}, nil
+}
+
+// send emits the lines of snapshots, split into datagrams that fit
+// statsdMaxPacket.
+func (s *statsdSink) send(snapshots []*Snapshot) error {
+	var buf bytes.Buffer
+	for _, line := range s.lines(snapshots) {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			if err := s.flush(&buf); err != nil {
+				return err
+			}
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	return s.flush(&buf)
+}
+
+// lines builds, for every action of every snapshot, a counter with the
+// packets since the previous call and a gauge with the current rate, under
+// the names from metricNames:
+//
+//	<name>.<action>:<delta>|c
+//	<name>.<action>.rate:<rate>|g
+func (s *statsdSink) lines(snapshots []*Snapshot) []string {
+	var lines []string
+	names := metricNames(s.prefix, snapshots, statsdName)
+	for i, snap := range snapshots {
+		for _, action := range snap.order {
+			a := snap.Actions[action]
+			name := names[i] + "." + statsdName(action)
+			prev, seen := s.prev[name]
+			s.prev[name] = a.Count
+			if seen && a.Count >= prev {
+				lines = append(lines, fmt.Sprintf("%s:%d|c", name, a.Count-prev))
+			}
+			if !snap.WarmingUp {
+				lines = append(lines, fmt.Sprintf("%s.rate:%.*f|g", name, precision, a.Rate))
+			}
+		}
+	}
+	return lines
+}
+
+func (s *statsdSink) flush(buf *bytes.Buffer) error {
+	if buf.Len() == 0 {
+		return nil
+	}
+	defer buf.Reset()
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+func (s *statsdSink) close() {
+	s.conn.Close()
+}
+
+// statsdName replaces characters that StatsD treats as separators.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', ' ', '\t', '/':
+			return '_'
+		}
+		return r
+	}, s)
+}
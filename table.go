@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// minColumnWidth is the smallest width of the count and rate columns, set
+// with --min-width.
+var minColumnWidth int
+
+// groupDigits separates thousands in the table counts, set with
+// --group-digits. Other outputs always use plain numbers.
+var groupDigits bool
+
+// columnWidths holds the widest name, count, rate and percentage seen so
+// far. Columns only ever widen, so the table does not jitter when a rate
+// drops by a digit.
+var columnWidths [4]int
+
+// tableRow is one line of the per-action or per-category table.
+type tableRow struct {
+	name     string
+	count    uint64
+	delta    *int64 // shown instead of count with --baseline
+	rate     float64
+	percent  float64
+	excluded bool
+	suffix   string
+}
+
+// writeRows writes rows as aligned "NAME: count (Rate: r/s, p%)" lines.
+func writeRows(w io.Writer, rows []tableRow, warmingUp bool) {
+	type cells struct{ name, count, rate, percent string }
+	formatted := make([]cells, len(rows))
+	for i, r := range rows {
+		c := cells{
+			name:    r.name + ":",
+			count:   formatCount(r.count),
+			rate:    fmt.Sprintf("%.*f/s", precision, r.rate),
+			percent: fmt.Sprintf("%.*f%%", precision, r.percent),
+		}
+		if r.delta != nil {
+			c.count = formatDelta(*r.delta)
+		}
+		if warmingUp {
+			c.rate = "warming up"
+		}
+		if r.excluded {
+			c.percent = "excluded from total"
+		}
+		for col, s := range []string{c.name, c.count, c.rate, c.percent} {
+			if col == 3 && r.excluded {
+				continue
+			}
+			width := len(s)
+			if col == 1 || col == 2 {
+				width = max(width, minColumnWidth)
+			}
+			columnWidths[col] = max(columnWidths[col], width)
+		}
+		formatted[i] = c
+	}
+
+	for i, c := range formatted {
+		nameW, countW, rateW, percentW := columnWidths[0], columnWidths[1], columnWidths[2], columnWidths[3]
+		if rows[i].excluded {
+			percentW = 0
+		}
+		fmt.Fprintf(w, "%-*s %*s (Rate: %*s, %*s)", nameW, c.name, countW, c.count, rateW, c.rate, percentW, c.percent)
+		if rows[i].suffix != "" {
+			fmt.Fprintf(w, " %s", rows[i].suffix)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// formatCount formats n for the table, with thousands separators if
+// --group-digits is set.
+func formatCount(n uint64) string {
+	s := strconv.FormatUint(n, 10)
+	if !groupDigits {
+		return s
+	}
+	var out []byte
+	for i := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			out = append(out, ',')
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+// formatDelta formats a --baseline delta with its sign.
+func formatDelta(d int64) string {
+	if d < 0 {
+		return "-" + formatCount(uint64(-d))
+	}
+	return "+" + formatCount(uint64(d))
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestFormatCount(t *testing.T) {
+	defer func(old bool) { groupDigits = old }(groupDigits)
+
+	for _, test := range []struct {
+		n              uint64
+		plain, grouped string
+	}{
+		{0, "0", "0"},
+		{999, "999", "999"},
+		{1000, "1000", "1,000"},
+		{123456, "123456", "123,456"},
+		{1234567, "1234567", "1,234,567"},
+		{18446744073709551615, "18446744073709551615", "18,446,744,073,709,551,615"},
+	} {
+		groupDigits = false
+		if got := formatCount(test.n); got != test.plain {
+			t.Errorf("formatCount(%d) = %q, want %q", test.n, got, test.plain)
+		}
+		groupDigits = true
+		if got := formatCount(test.n); got != test.grouped {
+			t.Errorf("formatCount(%d) with --group-digits = %q, want %q", test.n, got, test.grouped)
+		}
+	}
+}
+
+func TestFormatDelta(t *testing.T) {
+	for d, want := range map[int64]string{0: "+0", 12: "+12", -12: "-12"} {
+		if got := formatDelta(d); got != want {
+			t.Errorf("formatDelta(%d) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Netlink constants for tc filters, from linux/rtnetlink.h, linux/pkt_sched.h,
+// linux/pkt_cls.h and linux/tc_act/tc_bpf.h.
+const (
+	tcaKind       = 1
+	tcaOptions    = 2
+	tcaBPFID      = 11
+	tcaActKind    = 1
+	tcaActOptions = 2
+	tcaActBPFID   = 9
+	tcMsgLen      = 20         // struct tcmsg
+	tcHClsactIn   = 0xfffffff2 // TC_H_MAKE(TC_H_CLSACT, TC_H_MIN_INGRESS)
+	tcHClsactOut  = 0xfffffff3 // TC_H_MAKE(TC_H_CLSACT, TC_H_MIN_EGRESS)
+)
+
+// classidFilters lists the classifiers classidPrograms understands, with
+// the attributes holding their class ID and their action list, from
+// linux/pkt_cls.h.
+var classidFilters = map[string]struct{ classid, actions uint16 }{
+	"bpf":      {3, 1},
+	"u32":      {1, 7},
+	"basic":    {1, 3},
+	"flower":   {1, 3},
+	"matchall": {1, 2},
+}
+
+// openRoute opens a netlink socket for tc requests.
+func openRoute() (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+	return fd, nil
+}
+
+// clsactPrograms returns the IDs of the cls_bpf programs attached to the
+// clsact qdisc of the interface, for ingress and egress.
+func clsactPrograms(ifindex int) (ingress, egress []int, err error) {
+	fd, err := openRoute()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unix.Close(fd)
+
+	collect := func(ids *[]int) func(kind string, options []byte) {
+		return func(kind string, options []byte) {
+			if id, ok := bpfFilterID(kind, options); ok {
+				*ids = append(*ids, id)
+			}
+		}
+	}
+	if err := dumpFilters(fd, ifindex, tcHClsactIn, 1, collect(&ingress)); err != nil {
+		return nil, nil, err
+	}
+	if err := dumpFilters(fd, ifindex, tcHClsactOut, 2, collect(&egress)); err != nil {
+		return nil, nil, err
+	}
+	return ingress, egress, nil
+}
+
+// classidPrograms returns the IDs of the BPF programs that the filters of
+// the interface's root qdisc run for packets they put into classid: the
+// cls_bpf classifiers that select the class, and the act_bpf actions of
+// any filter that selects it.
+func classidPrograms(ifindex int, classid uint32) (cls, act []int, err error) {
+	fd, err := openRoute()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unix.Close(fd)
+
+	// A parent of 0 lists the filters of the root qdisc.
+	err = dumpFilters(fd, ifindex, 0, 1, func(kind string, options []byte) {
+		attrs, ok := classidFilters[kind]
+		if !ok {
+			return
+		}
+		var selects bool
+		var actions []byte
+		forEachAttr(options, func(typ uint16, value []byte) {
+			switch typ {
+			case attrs.classid:
+				selects = len(value) >= 4 && binary.NativeEndian.Uint32(value) == classid
+			case attrs.actions:
+				actions = value
+			}
+		})
+		if !selects {
+			return
+		}
+		if id, ok := bpfFilterID(kind, options); ok {
+			cls = append(cls, id)
+		}
+		act = append(act, bpfActionIDs(actions)...)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cls, act, nil
+}
+
+// dumpFilters lists the filters below parent on the interface and calls fn
+// with the kind and options of each. An interface without a qdisc there
+// has no filters.
+func dumpFilters(fd, ifindex int, parent uint32, seq uint32, fn func(kind string, options []byte)) error {
+	req := make([]byte, unix.SizeofNlMsghdr+tcMsgLen)
+	binary.NativeEndian.PutUint32(req[0:], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:], unix.RTM_GETTFILTER)
+	binary.NativeEndian.PutUint16(req[6:], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(req[8:], seq)
+	tcm := req[unix.SizeofNlMsghdr:]
+	tcm[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(tcm[4:], uint32(ifindex))
+	binary.NativeEndian.PutUint32(tcm[12:], parent)
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to request tc filters: %w", err)
+	}
+
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read tc filters: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to parse tc filters: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+						if syscall.Errno(errno) == unix.EINVAL || syscall.Errno(errno) == unix.ENOENT {
+							return nil // no such qdisc
+						}
+						return fmt.Errorf("failed to dump tc filters: %w", syscall.Errno(errno))
+					}
+				}
+				return nil
+			case unix.RTM_NEWTFILTER:
+				if len(m.Data) < tcMsgLen {
+					continue
+				}
+				var kind string
+				var options []byte
+				forEachAttr(m.Data[tcMsgLen:], func(typ uint16, value []byte) {
+					switch typ {
+					case tcaKind:
+						kind = string(trimNUL(value))
+					case tcaOptions:
+						options = value
+					}
+				})
+				fn(kind, options)
+			}
+		}
+	}
+}
+
+// bpfFilterID returns the program ID of a filter if it is a bpf filter.
+func bpfFilterID(kind string, options []byte) (int, bool) {
+	if kind != "bpf" {
+		return 0, false
+	}
+	return uint32Attr(options, tcaBPFID)
+}
+
+// bpfActionIDs returns the program IDs of the bpf actions in a filter's
+// action list.
+func bpfActionIDs(actions []byte) []int {
+	var ids []int
+	forEachAttr(actions, func(_ uint16, action []byte) {
+		var kind string
+		var options []byte
+		forEachAttr(action, func(typ uint16, value []byte) {
+			switch typ {
+			case tcaActKind:
+				kind = string(trimNUL(value))
+			case tcaActOptions:
+				options = value
+			}
+		})
+		if kind != "bpf" {
+			return
+		}
+		if id, ok := uint32Attr(options, tcaActBPFID); ok {
+			ids = append(ids, id)
+		}
+	})
+	return ids
+}
+
+// uint32Attr returns the value of the attribute typ in attrs as an int.
+func uint32Attr(attrs []byte, typ uint16) (int, bool) {
+	id, ok := 0, false
+	forEachAttr(attrs, func(t uint16, value []byte) {
+		if t == typ && len(value) >= 4 {
+			id, ok = int(binary.NativeEndian.Uint32(value)), true
+		}
+	})
+	return id, ok
+}
+
+// forEachAttr calls fn with the type and value of every netlink attribute
+// in b.
+func forEachAttr(b []byte, fn func(typ uint16, value []byte)) {
+	for len(b) >= unix.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(b))
+		if length < unix.SizeofRtAttr || length > len(b) {
+			return
+		}
+		fn(binary.NativeEndian.Uint16(b[2:])&^unix.NLA_F_NESTED, b[unix.SizeofRtAttr:length])
+		aligned := (length + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+		if aligned > len(b) {
+			return
+		}
+		b = b[aligned:]
+	}
+}
+
+func trimNUL(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// attr encodes a netlink attribute, padded to the attribute alignment.
+func attr(typ uint16, value []byte) []byte {
+	b := make([]byte, unix.SizeofRtAttr, unix.SizeofRtAttr+len(value)+unix.NLMSG_ALIGNTO)
+	binary.NativeEndian.PutUint16(b, uint16(unix.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(b[2:], typ)
+	b = append(b, value...)
+	for len(b)%unix.NLMSG_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func u32(v uint32) []byte {
+	return binary.NativeEndian.AppendUint32(nil, v)
+}
+
+func TestFilterProgramIDs(t *testing.T) {
+	options := slices.Concat(attr(3, u32(0x10010)), attr(tcaBPFID, u32(94)))
+	if id, ok := bpfFilterID("bpf", options); !ok || id != 94 {
+		t.Errorf("bpfFilterID = %d, %v, want 94", id, ok)
+	}
+	if _, ok := bpfFilterID("u32", options); ok {
+		t.Error("bpfFilterID of a u32 filter found a program")
+	}
+
+	bpfAction := attr(1, slices.Concat(
+		attr(tcaActKind, []byte("bpf\x00")),
+		attr(tcaActOptions, attr(tcaActBPFID, u32(7))),
+	))
+	otherAction := attr(2, slices.Concat(
+		attr(tcaActKind, []byte("gact\x00")),
+		attr(tcaActOptions, attr(tcaActBPFID, u32(8))),
+	))
+	if ids := bpfActionIDs(slices.Concat(bpfAction, otherAction)); !slices.Equal(ids, []int{7}) {
+		t.Errorf("bpfActionIDs = %v, want [7]", ids)
+	}
+}
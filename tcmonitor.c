@@ -5,22 +5,380 @@
 #include "vmlinux.h"
 #include <bpf/bpf_tracing.h>
 #include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
 
 #define TC_ACT_OK 0
+#define TC_ACT_SHOT 2
+#define TC_ACT_MAX 9
 
+#define ETH_P_IP 0x0800
+#define ETH_P_IPV6 0x86DD
+
+#define IPPROTO_ICMPV6 58
+
+#define ETH_ALEN 6
+
+#define EEXIST 17
+
+enum {
+    FAMILY_IPV4,
+    FAMILY_IPV6,
+    FAMILY_OTHER,
+    FAMILY_MAX,
+};
+
+enum {
+    CAST_UNICAST,
+    CAST_MULTICAST,
+    CAST_BROADCAST,
+    CAST_UNKNOWN, /* no Ethernet header */
+    CAST_MAX,
+};
+
+enum {
+    TTL_0_32,
+    TTL_33_64,
+    TTL_65_128,
+    TTL_129_255,
+    TTL_MAX,
+};
+
+/* Set from user space before loading. */
+volatile const bool by_family = false;
+volatile const bool icmp_detail = false;
+volatile const bool top_ports = false;
+volatile const bool by_cast = false;
+volatile const bool by_mark = false;
+volatile const bool ttl_dist = false;
+volatile const bool with_classid = false;
+
+/* Per-CPU, so that user space can tell which CPUs run the TC program. */
 struct {
-    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
     __type(key, __u32);
     __type(value, __u64);
-    __uint(max_entries, 9);
+    __uint(max_entries, TC_ACT_MAX);
 } tc_action_count_map SEC(".maps");
 
+/* Indexed by action * FAMILY_MAX + family. */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(max_entries, TC_ACT_MAX * FAMILY_MAX);
+} tc_family_count_map SEC(".maps");
+
+/* Indexed by action * CAST_MAX + cast. */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(max_entries, TC_ACT_MAX * CAST_MAX);
+} tc_cast_count_map SEC(".maps");
+
+/* Indexed by action * TTL_MAX + TTL (or hop limit) bucket. */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(max_entries, TC_ACT_MAX * TTL_MAX);
+} tc_ttl_count_map SEC(".maps");
+
+struct icmp_key {
+    __u8 family; /* 4 or 6 */
+    __u8 type;
+    __u8 code;
+    __u8 pad;
+};
+
+/* ICMP type/code of packets the TC program dropped. */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct icmp_key);
+    __type(value, __u64);
+    __uint(max_entries, 512);
+} tc_icmp_drop_map SEC(".maps");
+
+struct port_key {
+    __u32 action;
+    __u16 port; /* destination port, 0 for fragments */
+    __u8 proto;
+    __u8 fragment;
+};
+
+/* TCP/UDP destination ports per action, keeping the busiest ones. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct port_key);
+    __type(value, __u64);
+    __uint(max_entries, 4096);
+} tc_port_count_map SEC(".maps");
+
+struct mark_key {
+    __u32 action;
+    __u32 mark;
+};
+
+/* skb->mark per action after the TC program ran. Marks can be high
+ * cardinality, so only recently seen ones are kept. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct mark_key);
+    __type(value, __u64);
+    __uint(max_entries, 1024);
+} tc_mark_count_map SEC(".maps");
+
+struct classid_key {
+    __u32 action;
+    __u32 classid;
+};
+
+/* Class ID per action after the TC program ran, like the marks above. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct classid_key);
+    __type(value, __u64);
+    __uint(max_entries, 1024);
+} tc_classid_count_map SEC(".maps");
+
+#define IP_OFFSET 0x1fff
+#define NEXTHDR_FRAGMENT 44
+
+/* L3/L4 details of a packet, as far as tcmonitor needs them. */
+struct pkt_info {
+    __u8 family; /* 4 or 6 */
+    __u8 l4proto;
+    __u8 ttl; /* IPv4 TTL or IPv6 hop limit */
+    bool fragment; /* a non-first fragment carries no L4 header */
+    void *l4;
+};
+
+static __always_inline void *network_header(struct sk_buff *skb) {
+    return skb->head + skb->network_header;
+}
+
+/* Parses the IP header of skb. Returns false for non-IP or unreadable
+ * packets. IPv6 extension headers are not followed, except that a
+ * fragment header marks the packet as a fragment. */
+static __always_inline bool parse_packet(struct sk_buff *skb, struct pkt_info *pkt) {
+    void *nh = network_header(skb);
+
+    switch (bpf_ntohs(skb->protocol)) {
+    case ETH_P_IP: {
+        struct iphdr iph;
+        if (bpf_probe_read_kernel(&iph, sizeof(iph), nh)) {
+            return false;
+        }
+        pkt->family = 4;
+        pkt->l4proto = iph.protocol;
+        pkt->ttl = iph.ttl;
+        pkt->fragment = bpf_ntohs(iph.frag_off) & IP_OFFSET;
+        pkt->l4 = nh + iph.ihl * 4;
+        return true;
+    }
+    case ETH_P_IPV6: {
+        struct ipv6hdr ip6h;
+        if (bpf_probe_read_kernel(&ip6h, sizeof(ip6h), nh)) {
+            return false;
+        }
+        pkt->family = 6;
+        pkt->l4proto = ip6h.nexthdr;
+        pkt->ttl = ip6h.hop_limit;
+        pkt->fragment = ip6h.nexthdr == NEXTHDR_FRAGMENT;
+        pkt->l4 = nh + sizeof(ip6h);
+        return true;
+    }
+    default:
+        return false;
+    }
+}
+
+/* Increments the counter at key, creating it if needed. If another CPU
+ * creates it between the lookup and the update, the update fails with
+ * -EEXIST and the entry that CPU created is incremented instead, so the
+ * hit is not lost. */
+static __always_inline void increment(void *map, void *key) {
+    __u64 *count = bpf_map_lookup_elem(map, key);
+    if (!count) {
+        __u64 one = 1;
+        if (bpf_map_update_elem(map, key, &one, BPF_NOEXIST) != -EEXIST) {
+            return;
+        }
+        count = bpf_map_lookup_elem(map, key);
+        if (!count) {
+            return;
+        }
+    }
+    __sync_fetch_and_add(count, 1);
+}
+
+static __always_inline void count_icmp_drop(struct sk_buff *skb) {
+    struct pkt_info pkt;
+    if (!parse_packet(skb, &pkt) || pkt.fragment) {
+        return;
+    }
+    if (pkt.l4proto != (pkt.family == 4 ? IPPROTO_ICMP : IPPROTO_ICMPV6)) {
+        return;
+    }
+
+    /* ICMP and ICMPv6 headers both start with type and code. */
+    __u8 type_code[2];
+    if (bpf_probe_read_kernel(type_code, sizeof(type_code), pkt.l4)) {
+        return;
+    }
+    struct icmp_key key = {
+        .family = pkt.family,
+        .type = type_code[0],
+        .code = type_code[1],
+    };
+    increment(&tc_icmp_drop_map, &key);
+}
+
+static __always_inline void count_port(struct sk_buff *skb, int ret) {
+    struct pkt_info pkt;
+    if (!parse_packet(skb, &pkt)) {
+        return;
+    }
+
+    struct port_key key = {
+        .action = ret,
+        .proto = pkt.l4proto,
+    };
+    if (pkt.fragment) {
+        key.fragment = 1;
+    } else if (pkt.l4proto == IPPROTO_TCP || pkt.l4proto == IPPROTO_UDP) {
+        /* TCP and UDP headers both start with source and destination port. */
+        __be16 ports[2];
+        if (bpf_probe_read_kernel(ports, sizeof(ports), pkt.l4)) {
+            return;
+        }
+        key.port = bpf_ntohs(ports[1]);
+    } else {
+        return;
+    }
+    increment(&tc_port_count_map, &key);
+}
+
+static __always_inline void count_family(struct sk_buff *skb, int ret) {
+    if (ret < 0 || ret >= TC_ACT_MAX) {
+        return;
+    }
+
+    __u32 family;
+    switch (bpf_ntohs(skb->protocol)) {
+    case ETH_P_IP:
+        family = FAMILY_IPV4;
+        break;
+    case ETH_P_IPV6:
+        family = FAMILY_IPV6;
+        break;
+    default:
+        family = FAMILY_OTHER;
+    }
+
+    __u32 key = ret * FAMILY_MAX + family;
+    __u64 *count = bpf_map_lookup_elem(&tc_family_count_map, &key);
+    if (count) {
+        __sync_fetch_and_add(count, 1);
+    }
+}
+
+/* Classifies skb by its Ethernet destination address. */
+static __always_inline void count_cast(struct sk_buff *skb, int ret) {
+    if (ret < 0 || ret >= TC_ACT_MAX) {
+        return;
+    }
+
+    __u32 cast = CAST_UNKNOWN;
+    __u8 dst[ETH_ALEN];
+    if (skb->mac_header != (__u16)~0U && skb->mac_header != skb->network_header &&
+        !bpf_probe_read_kernel(dst, sizeof(dst), skb->head + skb->mac_header)) {
+        if ((dst[0] & dst[1] & dst[2] & dst[3] & dst[4] & dst[5]) == 0xff) {
+            cast = CAST_BROADCAST;
+        } else if (dst[0] & 1) {
+            cast = CAST_MULTICAST;
+        } else {
+            cast = CAST_UNICAST;
+        }
+    }
+
+    __u32 key = ret * CAST_MAX + cast;
+    increment(&tc_cast_count_map, &key);
+}
+
+static __always_inline void count_ttl(struct sk_buff *skb, int ret) {
+    if (ret < 0 || ret >= TC_ACT_MAX) {
+        return;
+    }
+    struct pkt_info pkt;
+    if (!parse_packet(skb, &pkt)) {
+        return;
+    }
+
+    __u32 bucket;
+    if (pkt.ttl <= 32) {
+        bucket = TTL_0_32;
+    } else if (pkt.ttl <= 64) {
+        bucket = TTL_33_64;
+    } else if (pkt.ttl <= 128) {
+        bucket = TTL_65_128;
+    } else {
+        bucket = TTL_129_255;
+    }
+
+    __u32 key = ret * TTL_MAX + bucket;
+    increment(&tc_ttl_count_map, &key);
+}
+
+/* Counts the class ID a direct-action classifier set through
+ * __sk_buff->tc_classid, which the kernel keeps in the 16-bit tc_classid
+ * of the qdisc control block of the skb. That is only the minor number:
+ * cls_bpf ORs in the major of the filter's classid, which fexit cannot
+ * see. 0 means the program did not set one. */
+static __always_inline void count_classid(struct sk_buff *skb, int ret) {
+    struct qdisc_skb_cb *cb = (struct qdisc_skb_cb *)skb->cb;
+    __u16 minor;
+    if (bpf_probe_read_kernel(&minor, sizeof(minor), &cb->tc_classid)) {
+        return;
+    }
+    struct classid_key key = {
+        .action = ret,
+        .classid = minor,
+    };
+    increment(&tc_classid_count_map, &key);
+}
+
 SEC("fexit/tc")
 int BPF_PROG(fexit_tc, struct sk_buff *skb, int ret) {
     bpf_printk("TC Fexit triggered.");
     __u64 *count = bpf_map_lookup_elem(&tc_action_count_map, &ret);
     if (count) {
-        __sync_fetch_and_add(count, 1);
+        *count += 1;
+    }
+    if (by_family) {
+        count_family(skb, ret);
+    }
+    if (icmp_detail && ret == TC_ACT_SHOT) {
+        count_icmp_drop(skb);
+    }
+    if (top_ports) {
+        count_port(skb, ret);
+    }
+    if (by_cast) {
+        count_cast(skb, ret);
+    }
+    if (ttl_dist) {
+        count_ttl(skb, ret);
+    }
+    if (by_mark) {
+        struct mark_key key = {
+            .action = ret,
+            .mark = skb->mark,
+        };
+        increment(&tc_mark_count_map, &key);
+    }
+    if (with_classid) {
+        count_classid(skb, ret);
     }
     return 0;
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+)
+
+// parseTemplate parses an output template and executes it once against an
+// empty Snapshot, so that references to fields that do not exist are
+// reported up front instead of on every refresh.
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, &Snapshot{Actions: map[string]ActionStats{}}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// loadTemplate reads and validates the --template-file at path.
+func loadTemplate(path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := parseTemplate(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", path, err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errTimeout = errors.New("timed out")
+
+// withTimeout runs fn and returns its error, or errTimeout if fn has not
+// returned after d. fn keeps running in the background after a timeout, so
+// callers are expected to exit. A zero d waits forever.
+func withTimeout(d time.Duration, fn func() error) error {
+	if d <= 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errTimeout
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v0.1.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// ebpfLibVersion returns the cilium/ebpf module version tcmonitor was built
+// against.
+func ebpfLibVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/cilium/ebpf" {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+func versionString() string {
+	return fmt.Sprintf("tcmonitor-ebpf %s (commit %s, built %s, cilium/ebpf %s)", version, commit, date, ebpfLibVersion())
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// maxWatchdogBackoff caps the delay between failed re-attach attempts.
+const maxWatchdogBackoff = 5 * time.Minute
+
+// counterMaps returns the hook's maps by name, for reuse as
+// MapReplacements so counters survive a re-attach.
+func (h *fexitHook) counterMaps() map[string]*ebpf.Map {
+	return map[string]*ebpf.Map{
+		"tc_action_count_map":  h.obj.TcActionCountMap,
+		"tc_family_count_map":  h.obj.TcFamilyCountMap,
+		"tc_cast_count_map":    h.obj.TcCastCountMap,
+		"tc_icmp_drop_map":     h.obj.TcIcmpDropMap,
+		"tc_port_count_map":    h.obj.TcPortCountMap,
+		"tc_mark_count_map":    h.obj.TcMarkCountMap,
+		"tc_ttl_count_map":     h.obj.TcTtlCountMap,
+		"tc_classid_count_map": h.obj.TcClassidCountMap,
+	}
+}
+
+// checkLink verifies that the hook's tracing link is still valid and
+// re-attaches the hook if it is not. Failed attempts back off exponentially,
+// starting at interval.
+func (h *fexitHook) checkLink(spec *ebpf.CollectionSpec, interval time.Duration) {
+	now := time.Now()
+	if h.prog == nil || now.Before(h.nextAttach) {
+		return
+	}
+	if _, err := h.link.Info(); err == nil {
+		h.backoff = 0
+		return
+	}
+
+	h.logEvent("detach", "Tracing link for %s is gone, re-attaching", h.funcName)
+	obj, l, err := attachFexit(spec, h.prog, h.funcName, h.cookie, h.counterMaps())
+	if err != nil {
+		h.backoff = min(max(2*h.backoff, interval), maxWatchdogBackoff)
+		h.nextAttach = now.Add(h.backoff)
+		h.logEvent("attach_error", "Failed to re-attach %s, retrying in %v: %v", h.funcName, h.backoff, err)
+		return
+	}
+
+	h.link.Close()
+	h.obj.Close()
+	h.obj, h.link = obj, l
+	h.backoff = 0
+	h.logEvent("attach", "Re-attached %s", h.funcName)
+}